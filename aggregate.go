@@ -0,0 +1,179 @@
+package main
+
+import "C"
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// aggAccumulatorVar is the variable holding the running accumulator in fold step expressions
+const aggAccumulatorVar = "acc"
+
+// celAggState is the per-group accumulator of a CEL-driven aggregate
+type celAggState struct {
+	acc   ref.Val
+	count int64
+}
+
+// Aggregate states live in Go and are referenced from PostgreSQL by handle;
+// the C side releases them when the aggregate memory context is reset
+var (
+	aggStatesMu   sync.Mutex
+	aggStates     = make(map[int64]*celAggState)
+	nextAggHandle int64
+)
+
+// registerAggState stores a new aggregate state and returns its handle
+func registerAggState(state *celAggState) int64 {
+	aggStatesMu.Lock()
+	defer aggStatesMu.Unlock()
+
+	nextAggHandle++
+	aggStates[nextAggHandle] = state
+	return nextAggHandle
+}
+
+// lookupAggState returns the aggregate state for a handle
+func lookupAggState(handle int64) (*celAggState, error) {
+	aggStatesMu.Lock()
+	defer aggStatesMu.Unlock()
+
+	state, found := aggStates[handle]
+	if !found {
+		return nil, fmt.Errorf("CEL aggregate error: unknown aggregate state %d", handle)
+	}
+	return state, nil
+}
+
+// foldStep evaluates the step expression with the document fields plus the accumulator;
+// a document variable named like the accumulator is rejected rather than shadowed
+func foldStep(state *celAggState, stepExpr string, doc map[string]any) error {
+	if _, found := doc[aggAccumulatorVar]; found {
+		return fmt.Errorf("document variable %q conflicts with the accumulator; rename the key or set pg_cel.document_variable", aggAccumulatorVar)
+	}
+
+	// Copy the document so the cached parsed JSON is not modified
+	vars := make(map[string]any, len(doc)+1)
+	for key, value := range doc {
+		vars[key] = value
+	}
+	vars[aggAccumulatorVar] = state.acc
+
+	out, err := evalRule(stepExpr, vars)
+	if err != nil {
+		return err
+	}
+
+	state.acc = out
+	return nil
+}
+
+//export pg_cel_agg_fold_init
+func pg_cel_agg_fold_init(initStr *C.char, errorOut **C.char) C.longlong {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	// The initial value is evaluated once per group without document variables
+	acc, err := evalRule(C.GoString(initStr), map[string]any{})
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("CEL aggregate init: %v", err))
+		return 0
+	}
+
+	return C.longlong(registerAggState(&celAggState{acc: acc}))
+}
+
+//export pg_cel_agg_fold_step
+func pg_cel_agg_fold_step(handle C.longlong, stepStr *C.char, jsonData *C.char, errorOut **C.char) {
+	state, err := lookupAggState(int64(handle))
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return
+	}
+
+	doc, err := parseDocument(C.GoString(jsonData))
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return
+	}
+
+	if err := foldStep(state, C.GoString(stepStr), doc); err != nil {
+		*errorOut = C.CString(fmt.Sprintf("CEL aggregate step: %v", err))
+	}
+}
+
+//export pg_cel_agg_fold_result
+func pg_cel_agg_fold_result(handle C.longlong, errorOut **C.char) *C.char {
+	state, err := lookupAggState(int64(handle))
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	value, err := celValueToJSON(state.acc)
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("CEL aggregate result: CEL result conversion error: %v", err))
+		return nil
+	}
+
+	return C.CString(string(value))
+}
+
+//export pg_cel_agg_count_if_init
+func pg_cel_agg_count_if_init() C.longlong {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	return C.longlong(registerAggState(&celAggState{}))
+}
+
+//export pg_cel_agg_count_if_step
+func pg_cel_agg_count_if_step(handle C.longlong, exprStr *C.char, jsonData *C.char, errorOut **C.char) {
+	state, err := lookupAggState(int64(handle))
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return
+	}
+
+	doc, err := parseDocument(C.GoString(jsonData))
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return
+	}
+
+	out, err := evalRule(C.GoString(exprStr), doc)
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("CEL aggregate step: %v", err))
+		return
+	}
+	matched, ok := out.(types.Bool)
+	if !ok {
+		*errorOut = C.CString(fmt.Sprintf("CEL aggregate step: expected bool result, got %s", out.Type().TypeName()))
+		return
+	}
+
+	if matched {
+		state.count++
+	}
+}
+
+//export pg_cel_agg_count_if_result
+func pg_cel_agg_count_if_result(handle C.longlong) C.longlong {
+	state, err := lookupAggState(int64(handle))
+	if err != nil {
+		return 0
+	}
+	return C.longlong(state.count)
+}
+
+//export pg_cel_agg_release
+func pg_cel_agg_release(handle C.longlong) {
+	aggStatesMu.Lock()
+	defer aggStatesMu.Unlock()
+
+	delete(aggStates, int64(handle))
+}
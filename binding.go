@@ -0,0 +1,120 @@
+package main
+
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/google/cel-go/cel"
+)
+
+// documentCacheKeyPrefix keeps documents parsed into expression variables apart from plain parsed JSON
+const documentCacheKeyPrefix = "document|"
+
+// rootVariable is bound to the whole object document in per-key mode
+const rootVariable = "_doc"
+
+// documentVariable is the variable the whole document is bound to when pg_cel.document_variable
+// is set; when empty, every top-level key of an object document becomes its own variable.
+// It is only changed by the GUC assign hook on the backend thread.
+var documentVariable string
+
+// celIdentifierPattern matches CEL identifiers
+var celIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// celReservedWords cannot be declared as variables
+var celReservedWords = map[string]bool{
+	"true": true, "false": true, "null": true, "in": true,
+	"as": true, "break": true, "const": true, "continue": true, "else": true,
+	"for": true, "function": true, "if": true, "import": true, "let": true,
+	"loop": true, "package": true, "namespace": true, "return": true,
+	"var": true, "void": true, "while": true,
+}
+
+// isCELIdentifier reports whether a name can be declared as a CEL variable
+func isCELIdentifier(name string) bool {
+	return celIdentifierPattern.MatchString(name) && !celReservedWords[name]
+}
+
+// variableType returns the CEL type a document variable is declared with. The single document
+// variable is dyn, so one program serves documents of every shape.
+func variableType(name string, value any) *cel.Type {
+	if documentVariable != "" && name == documentVariable {
+		return cel.DynType
+	}
+	return getCELType(value)
+}
+
+// documentVariables declares each top-level key of an object document that is a CEL identifier
+// as its own variable. Keys that are not ("first-name", "in", "1st", "@type") are skipped and
+// stay reachable by indexing the whole object, bound to rootVariable: _doc["first-name"].
+// A top-level key named like the root variable takes precedence over it.
+func documentVariables(object map[string]any) map[string]any {
+	env := make(map[string]any, len(object)+1)
+	env[rootVariable] = object
+	for key, value := range object {
+		if isCELIdentifier(key) {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// parseDocument parses a JSON document into the variables expressions are evaluated with:
+// one variable per top-level key plus the root variable, or the whole document (of any
+// JSON type) bound to pg_cel.document_variable
+func parseDocument(jsonString string) (map[string]any, error) {
+	// Try to get parsed JSON from cache
+	cacheKey := jsonCacheKey(documentCacheKeyPrefix+documentVariable, jsonString)
+	if cachedEnv, found := getCachedJSON(cacheKey); found {
+		return cachedEnv, nil
+	}
+
+	// Parse JSON (cache miss)
+	var env map[string]any
+	var cost int64
+	if documentVariable != "" {
+		// Arrays and scalars are accepted as roots
+		var document any = map[string]any{}
+		if jsonString != "" {
+			if err := json.Unmarshal([]byte(jsonString), &document); err != nil {
+				return nil, fmt.Errorf("JSON parsing error: %v", err)
+			}
+		}
+		env = map[string]any{documentVariable: document}
+		cost = mapCost(len(env)) + jsonValueCost(document)
+	} else {
+		object := make(map[string]any)
+		if jsonString != "" {
+			if err := json.Unmarshal([]byte(jsonString), &object); err != nil {
+				return nil, fmt.Errorf("JSON parsing error: %v", err)
+			}
+		}
+		if object == nil {
+			object = make(map[string]any)
+		}
+		env = documentVariables(object)
+		// Variables share their keys and values with the root object
+		cost = mapCost(len(env)) + jsonValueCost(object)
+	}
+
+	cacheJSON(cacheKey, jsonString, env, cost)
+
+	return env, nil
+}
+
+//export pg_cel_check_document_variable
+func pg_cel_check_document_variable(name *C.char) C.int {
+	variable := C.GoString(name)
+	if variable == "" || isCELIdentifier(variable) {
+		return 1
+	}
+	return 0
+}
+
+//export pg_cel_set_document_variable
+func pg_cel_set_document_variable(name *C.char) {
+	documentVariable = C.GoString(name)
+}
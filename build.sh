@@ -71,7 +71,7 @@ download_deps() {
 # Build the extension
 build_extension() {
     log "Building Go archive..."
-    go build -buildmode=c-archive -o pg_cel_go.a main.go
+    go build -buildmode=c-archive -o pg_cel_go.a $(ls *.go | grep -v _test.go)
     if [ $? -ne 0 ]; then
         error "Failed to build Go archive"
         return 1
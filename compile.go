@@ -0,0 +1,336 @@
+package main
+
+import "C"
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"time"
+	"unsafe"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/decls"
+	"github.com/google/cel-go/common/types"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/proto"
+)
+
+// compiledProgram is a program planned from a stored checked expression, with the
+// types its variables were declared with when it was compiled
+type compiledProgram struct {
+	cel.Program
+	variables map[string]*types.Type
+}
+
+// compileChecked type-checks an expression against declared variables and serializes
+// the checked expression (google.api.expr.v1alpha1.CheckedExpr)
+func compileChecked(exprString string, declarations map[string]*cel.Type) ([]byte, error) {
+	celEnv, err := createDeclaredCELEnv(declarations)
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %v", err)
+	}
+
+	checked, issues := celEnv.Compile(exprString)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("CEL compilation error: %v", issues.Err())
+	}
+
+	checkedExpr, err := cel.AstToCheckedExpr(checked)
+	if err != nil {
+		return nil, fmt.Errorf("CEL serialization error: %v", err)
+	}
+	serialized, err := proto.Marshal(checkedExpr)
+	if err != nil {
+		return nil, fmt.Errorf("CEL serialization error: %v", err)
+	}
+	return serialized, nil
+}
+
+// storedVariables returns the variables a checked expression references with their stored
+// types; variables are references without overloads or constant values
+func storedVariables(checked *cel.Ast) map[string]*types.Type {
+	native := checked.NativeRep()
+	variables := make(map[string]*types.Type)
+	for id, reference := range native.ReferenceMap() {
+		if reference.Value == nil && len(reference.OverloadIDs) == 0 {
+			variables[reference.Name] = native.GetType(id)
+		}
+	}
+	return variables
+}
+
+// validateCheckedExpr checks the structure the planner relies on without type-checking
+// again: every node is typed, identifiers and calls carry references, and each call's
+// overloads exist in the environment with the call's arity. The bytes may come from
+// anywhere, and the planner trusts checked ASTs, so malformed ones would crash the backend.
+func validateCheckedExpr(celEnv *cel.Env, checked *exprpb.CheckedExpr) error {
+	functions := celEnv.Functions()
+
+	var validate func(expr *exprpb.Expr) error
+	validate = func(expr *exprpb.Expr) error {
+		if expr == nil {
+			return fmt.Errorf("missing expression")
+		}
+		id := expr.GetId()
+		if _, typed := checked.GetTypeMap()[id]; !typed {
+			return fmt.Errorf("expression %d has no type", id)
+		}
+		reference := checked.GetReferenceMap()[id]
+
+		switch kind := expr.GetExprKind().(type) {
+		case *exprpb.Expr_ConstExpr:
+			if kind.ConstExpr.GetConstantKind() == nil {
+				return fmt.Errorf("constant %d has no value", id)
+			}
+		case *exprpb.Expr_IdentExpr:
+			if reference == nil {
+				return fmt.Errorf("identifier %d has no reference", id)
+			}
+		case *exprpb.Expr_SelectExpr:
+			return validate(kind.SelectExpr.GetOperand())
+		case *exprpb.Expr_CallExpr:
+			call := kind.CallExpr
+			if reference == nil || len(reference.GetOverloadId()) == 0 {
+				return fmt.Errorf("call %d has no overloads", id)
+			}
+			function, found := functions[call.GetFunction()]
+			if !found {
+				return fmt.Errorf("call %d: unknown function %s", id, call.GetFunction())
+			}
+			arity := len(call.GetArgs())
+			if call.GetTarget() != nil {
+				arity++
+			}
+			for _, overloadID := range reference.GetOverloadId() {
+				if !hasOverload(function, overloadID, call.GetTarget() != nil, arity) {
+					return fmt.Errorf("call %d: no overload %s of %s taking %d arguments", id, overloadID, call.GetFunction(), arity)
+				}
+			}
+			if call.GetTarget() != nil {
+				if err := validate(call.GetTarget()); err != nil {
+					return err
+				}
+			}
+			for _, arg := range call.GetArgs() {
+				if err := validate(arg); err != nil {
+					return err
+				}
+			}
+		case *exprpb.Expr_ListExpr:
+			elements := kind.ListExpr.GetElements()
+			for _, index := range kind.ListExpr.GetOptionalIndices() {
+				if index < 0 || int(index) >= len(elements) {
+					return fmt.Errorf("list %d: optional index %d out of range", id, index)
+				}
+			}
+			for _, element := range elements {
+				if err := validate(element); err != nil {
+					return err
+				}
+			}
+		case *exprpb.Expr_StructExpr:
+			for _, entry := range kind.StructExpr.GetEntries() {
+				if entry.GetKeyKind() == nil {
+					return fmt.Errorf("struct %d: entry has no key", id)
+				}
+				if mapKey := entry.GetMapKey(); mapKey != nil {
+					if err := validate(mapKey); err != nil {
+						return err
+					}
+				}
+				if err := validate(entry.GetValue()); err != nil {
+					return err
+				}
+			}
+		case *exprpb.Expr_ComprehensionExpr:
+			comprehension := kind.ComprehensionExpr
+			if comprehension.GetIterVar() == "" || comprehension.GetAccuVar() == "" {
+				return fmt.Errorf("comprehension %d has no variables", id)
+			}
+			for _, part := range []*exprpb.Expr{
+				comprehension.GetIterRange(), comprehension.GetAccuInit(),
+				comprehension.GetLoopCondition(), comprehension.GetLoopStep(), comprehension.GetResult(),
+			} {
+				if err := validate(part); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("expression %d has no kind", id)
+		}
+		return nil
+	}
+
+	return validate(checked.GetExpr())
+}
+
+// hasOverload reports whether a function declares an overload with the given id,
+// receiver style and number of arguments (including the receiver)
+func hasOverload(function *decls.FunctionDecl, overloadID string, member bool, arity int) bool {
+	for _, overload := range function.OverloadDecls() {
+		if overload.ID() == overloadID {
+			return overload.IsMemberFunction() == member && len(overload.ArgTypes()) == arity
+		}
+	}
+	return false
+}
+
+// getCompiledProgram plans a program from a serialized checked expression without
+// parsing or checking it again; the structure is validated before it is planned
+func getCompiledProgram(serialized []byte) (*compiledProgram, error) {
+	digest := sha256.Sum256(serialized)
+	cacheKey := compiledEnvironment.cacheKey(string(digest[:]))
+
+	// Try to get planned program from cache
+	if cachedProgram, found := programCache.Get(cacheKey); found {
+		if prg, ok := cachedProgram.(*compiledProgram); ok {
+			recordCacheHit(prg.Program)
+			return prg, nil
+		}
+	}
+
+	started := time.Now()
+	var checkedExpr exprpb.CheckedExpr
+	if err := proto.Unmarshal(serialized, &checkedExpr); err != nil {
+		return nil, fmt.Errorf("compiled expression decoding error: %v", err)
+	}
+	if checkedExpr.GetExpr() == nil || len(checkedExpr.GetTypeMap()) == 0 {
+		return nil, fmt.Errorf("compiled expression decoding error: not a checked CEL expression")
+	}
+
+	celEnv, err := createDeclaredCELEnv(nil)
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %v", err)
+	}
+	if err := validateCheckedExpr(celEnv, &checkedExpr); err != nil {
+		return nil, fmt.Errorf("compiled expression decoding error: not a valid checked CEL expression: %v", err)
+	}
+	// cel_compile stores no macro calls; any present are not trusted for unparsing
+	if sourceInfo := checkedExpr.GetSourceInfo(); sourceInfo != nil {
+		sourceInfo.MacroCalls = nil
+	}
+	checked := cel.CheckedExprToAst(&checkedExpr)
+
+	prg, err := celEnv.Program(checked)
+	if err != nil {
+		return nil, fmt.Errorf("CEL program creation error: %v", err)
+	}
+	// Compiled expressions are profiled under their canonical text
+	label, err := unparseExpr(checked.NativeRep(), checked.NativeRep().Expr(), 0)
+	if err != nil {
+		label = "<compiled>"
+	}
+	prg = profileProgram(compiledEnvironment, label, prg, started)
+
+	compiled := &compiledProgram{Program: prg, variables: storedVariables(checked)}
+
+	// Cache the planned program
+	cacheProgram(cacheKey, compiledEnvironment, label, compiled, programCost(checked))
+
+	return compiled, nil
+}
+
+// coerceJSONValue converts JSON numbers to the integer types a variable was declared with,
+// copying containers so the cached parsed document is not modified
+func coerceJSONValue(value any, celType *types.Type) any {
+	switch celType.Kind() {
+	case types.IntKind:
+		if number, ok := value.(float64); ok && number == math.Trunc(number) {
+			return int64(number)
+		}
+	case types.UintKind:
+		if number, ok := value.(float64); ok && number >= 0 && number == math.Trunc(number) {
+			return uint64(number)
+		}
+	case types.ListKind:
+		if list, ok := value.([]any); ok {
+			coerced := make([]any, len(list))
+			for i, elem := range list {
+				coerced[i] = coerceJSONValue(elem, celType.Parameters()[0])
+			}
+			return coerced
+		}
+	case types.MapKind:
+		if object, ok := value.(map[string]any); ok {
+			coerced := make(map[string]any, len(object))
+			for key, elem := range object {
+				coerced[key] = coerceJSONValue(elem, celType.Parameters()[1])
+			}
+			return coerced
+		}
+	}
+	return value
+}
+
+// evalCompiled evaluates a stored checked expression against a parsed document
+func evalCompiled(serialized []byte, env map[string]any) (string, error) {
+	compiled, err := getCompiledProgram(serialized)
+	if err != nil {
+		return "", err
+	}
+
+	activation := make(map[string]any, len(env))
+	for name, value := range env {
+		if celType, declared := compiled.variables[name]; declared {
+			value = coerceJSONValue(value, celType)
+		}
+		activation[name] = value
+	}
+
+	out, _, err := compiled.Eval(activation)
+	if err != nil {
+		return "", fmt.Errorf("CEL evaluation error: %v", err)
+	}
+
+	value, err := celValueToJSON(out)
+	if err != nil {
+		return "", fmt.Errorf("CEL result conversion error: %v", err)
+	}
+	return string(value), nil
+}
+
+//export pg_cel_compile
+func pg_cel_compile(expressionStr *C.char, declarationsStr *C.char, resultLen *C.int, errorOut **C.char) *C.char {
+	// Convert C strings to Go strings
+	exprString := C.GoString(expressionStr)
+	declarationsString := C.GoString(declarationsStr)
+
+	declarations, err := parseDeclarations(declarationsString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	serialized, err := compileChecked(exprString, declarations)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	*resultLen = C.int(len(serialized))
+	return (*C.char)(C.CBytes(serialized))
+}
+
+//export pg_cel_eval_compiled
+func pg_cel_eval_compiled(compiledData *C.char, compiledLen C.int, jsonData *C.char, errorOut **C.char) *C.char {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	serialized := C.GoBytes(unsafe.Pointer(compiledData), compiledLen)
+	jsonString := C.GoString(jsonData)
+
+	env, err := parseJSONData(jsonString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	result, err := evalCompiled(serialized, env)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	return C.CString(result)
+}
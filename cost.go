@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+)
+
+// Approximate heap footprint of cached values in bytes, measured with runtime.MemStats
+// on 64-bit platforms by cost_test.go (make cost-model). Cache costs are bytes, so
+// pg_cel.program_cache_size_mb and pg_cel.json_cache_size_mb bound the memory the caches hold.
+const (
+	// programBaseCost is the environment, type registry and function dispatcher each
+	// cached program keeps alive; it dominates the cost of small expressions
+	programBaseCost = 96 * 1024
+	// programNodeCost is one AST node with its type and reference entries and its
+	// planned interpretable
+	programNodeCost = 96
+
+	// mapBucketCost is a Go map bucket holding up to 6.5 string keys with interface values
+	// on average, and mapHeaderCost the map header
+	mapBucketCost = 272
+	mapHeaderCost = 48
+	// stringHeaderCost, sliceHeaderCost and interfaceCost are the fixed parts of a decoded
+	// string, array and array element; numbers are boxed in 8 bytes
+	stringHeaderCost = 16
+	sliceHeaderCost  = 24
+	interfaceCost    = 16
+	numberCost       = 8
+)
+
+// programCost estimates the memory a cached program holds from the size of its checked AST
+func programCost(checked *cel.Ast) int64 {
+	native := checked.NativeRep()
+
+	nodes := 0
+	ast.PostOrderVisit(native.Expr(), ast.NewExprVisitor(func(ast.Expr) {
+		nodes++
+	}))
+
+	cost := int64(programBaseCost + nodes*programNodeCost)
+	if source := checked.Source(); source != nil {
+		cost += int64(len(source.Content()))
+	}
+	return cost
+}
+
+// mapCost estimates the memory of a map[string]any with the given number of entries,
+// excluding the keys' bytes and the values
+func mapCost(entries int) int64 {
+	buckets := (entries*2 + 12) / 13 // ceil(entries / 6.5)
+	if buckets < 1 {
+		buckets = 1
+	}
+	return int64(mapHeaderCost + buckets*mapBucketCost)
+}
+
+// jsonValueCost estimates the memory of a value decoded by encoding/json
+func jsonValueCost(value any) int64 {
+	switch v := value.(type) {
+	case map[string]any:
+		cost := mapCost(len(v))
+		for key, elem := range v {
+			cost += int64(len(key)) + jsonValueCost(elem)
+		}
+		return cost
+	case []any:
+		cost := int64(sliceHeaderCost + cap(v)*interfaceCost)
+		for _, elem := range v {
+			cost += jsonValueCost(elem)
+		}
+		return cost
+	case string:
+		return int64(stringHeaderCost + len(v))
+	case float64:
+		return numberCost
+	default:
+		// Booleans and null are stored in the interface itself
+		return 0
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+)
+
+// The cost constants in cost.go are re-derived here from runtime.MemStats. The package
+// links against the PostgreSQL server, so the Go files are tested without pg_wrapper.c:
+//
+//	make cost-model
+
+// retainedBytes returns the heap bytes still reachable after build runs count times
+func retainedBytes(count int, build func() any) int64 {
+	kept := make([]any, count)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := range kept {
+		kept[i] = build()
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(kept)
+
+	return (int64(after.HeapAlloc) - int64(before.HeapAlloc)) / int64(count)
+}
+
+// measureProgram compiles and plans an expression the way getDocumentProgram does on a
+// cache miss and returns the bytes each program retains with its checked AST
+func measureProgram(t *testing.T, exprString string) (int64, *cel.Ast) {
+	declarations := map[string]*cel.Type{"x": cel.DoubleType}
+	var checked *cel.Ast
+	retained := retainedBytes(50, func() any {
+		celEnv, err := createDeclaredCELEnv(declarations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast, issues := celEnv.Compile(exprString)
+		if issues != nil && issues.Err() != nil {
+			t.Fatal(issues.Err())
+		}
+		prg, err := newProgram(celEnv, ast, exprString)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checked = ast
+		return prg
+	})
+	return retained, checked
+}
+
+// withinFactor reports whether an estimate is within a factor of two of a measurement
+func withinFactor(estimate, measured int64) bool {
+	return estimate*2 >= measured && measured*2 >= estimate
+}
+
+func TestProgramCostModel(t *testing.T) {
+	small, smallAST := measureProgram(t, "x")
+	large, largeAST := measureProgram(t, "x"+strings.Repeat(" + x", 200))
+
+	nodes := func(checked *cel.Ast) int64 {
+		return (programCost(checked) - programBaseCost - int64(len(checked.Source().Content()))) / programNodeCost
+	}
+	perNode := (large - small) / (nodes(largeAST) - nodes(smallAST))
+
+	t.Logf("program base: measured %d bytes, programBaseCost %d", small, programBaseCost)
+	t.Logf("program node: measured %d bytes, programNodeCost %d", perNode, programNodeCost)
+	if !withinFactor(programBaseCost, small) {
+		t.Errorf("programBaseCost %d is not within 2x of the measured %d bytes", programBaseCost, small)
+	}
+	if !withinFactor(programNodeCost, perNode) {
+		t.Errorf("programNodeCost %d is not within 2x of the measured %d bytes", programNodeCost, perNode)
+	}
+}
+
+func TestJSONCostModel(t *testing.T) {
+	documents := []string{
+		`{"a": 1, "b": "text", "c": true}`,
+		`{"user": {"name": "Ada", "age": 36, "tags": ["x", "y", "z"]}, "scores": [1, 2, 3, 4, 5, 6, 7, 8]}`,
+		`{"items": [` + strings.Repeat(`{"id": 1, "name": "item"}, `, 50) + `{"id": 2, "name": "last"}]}`,
+	}
+	for _, document := range documents {
+		var decoded any
+		measured := retainedBytes(200, func() any {
+			var value any
+			if err := json.Unmarshal([]byte(document), &value); err != nil {
+				t.Fatal(err)
+			}
+			decoded = value
+			return value
+		})
+		estimate := jsonValueCost(decoded)
+
+		t.Logf("document of %d bytes: measured %d bytes, estimated %d", len(document), measured, estimate)
+		if !withinFactor(estimate, measured) {
+			t.Errorf("jsonValueCost %d is not within 2x of the measured %d bytes for %s", estimate, measured, document)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+)
+
+// coverageCacheKeyPrefix keeps coverage-instrumented programs apart from plain programs,
+// so toggling pg_cel.track_coverage takes effect for expressions that are already cached
+const coverageCacheKeyPrefix = "coverage|"
+
+// coverageNode counts how often one sub-expression was evaluated and what it produced
+type coverageNode struct {
+	ID         int64  `json:"id"`
+	Depth      int    `json:"depth"`
+	Expression string `json:"expression"`
+	Hits       int64  `json:"hits"`
+	TrueHits   int64  `json:"true_hits"`
+	FalseHits  int64  `json:"false_hits"`
+	ErrorHits  int64  `json:"error_hits"`
+
+	// parent is the index of the enclosing node; selectOperand marks the operand of a field
+	// selection, which is read as part of the selection and has no evaluation state of its own
+	parent        int
+	selectOperand bool
+}
+
+// expressionCoverage is the coverage collected for one expression text
+type expressionCoverage struct {
+	Expression  string          `json:"expression"`
+	Evaluations int64           `json:"evaluations"`
+	Nodes       []*coverageNode `json:"nodes"`
+}
+
+// Coverage is collected per backend while pg_cel.track_coverage is on.
+// coverageEnabled is only changed by the GUC assign hook on the backend thread.
+var (
+	coverageEnabled bool
+	coverageMu      sync.Mutex
+	coverageByExpr  = make(map[string]*expressionCoverage)
+)
+
+// programCacheKey returns the cache key for a program compiled in an environment,
+// separating instrumented programs
+func programCacheKey(environment celEnvironment, key string) string {
+	key = environment.cacheKey(key)
+	if coverageEnabled {
+		return coverageCacheKeyPrefix + key
+	}
+	return key
+}
+
+// coverageCollector lists the user-written sub-expressions of a parsed expression
+type coverageCollector struct {
+	parsed *ast.AST
+	byID   map[int64]ast.Expr
+	nodes  []*coverageNode
+}
+
+// index records every node of the expanded AST so macro arguments can be resolved by id
+func (c *coverageCollector) index(expr ast.Expr) {
+	c.byID[expr.ID()] = expr
+	switch expr.Kind() {
+	case ast.SelectKind:
+		c.index(expr.AsSelect().Operand())
+	case ast.CallKind:
+		call := expr.AsCall()
+		if call.IsMemberFunction() {
+			c.index(call.Target())
+		}
+		for _, arg := range call.Args() {
+			c.index(arg)
+		}
+	case ast.ListKind:
+		for _, elem := range expr.AsList().Elements() {
+			c.index(elem)
+		}
+	case ast.MapKind:
+		for _, entry := range expr.AsMap().Entries() {
+			c.index(entry.AsMapEntry().Key())
+			c.index(entry.AsMapEntry().Value())
+		}
+	case ast.ComprehensionKind:
+		comp := expr.AsComprehension()
+		c.index(comp.IterRange())
+		c.index(comp.AccuInit())
+		c.index(comp.LoopCondition())
+		c.index(comp.LoopStep())
+		c.index(comp.Result())
+	}
+}
+
+// visit adds a node and its operands in source order. For comprehensions the macro
+// arguments (the predicate of all(), exists(), filter() ...) are visited instead of the
+// generated loop, so coverage shows which parts of a predicate ever ran.
+func (c *coverageCollector) visit(expr ast.Expr, depth int, parent int, selectOperand bool) {
+	node := &coverageNode{ID: expr.ID(), Depth: depth, parent: parent, selectOperand: selectOperand}
+	if text, err := unparseExpr(c.parsed, expr, 0); err == nil {
+		node.Expression = text
+	}
+	c.nodes = append(c.nodes, node)
+	self := len(c.nodes) - 1
+
+	switch expr.Kind() {
+	case ast.SelectKind:
+		c.visit(expr.AsSelect().Operand(), depth+1, self, true)
+	case ast.CallKind:
+		call := expr.AsCall()
+		if call.IsMemberFunction() {
+			c.visit(call.Target(), depth+1, self, false)
+		}
+		for _, arg := range call.Args() {
+			c.visit(arg, depth+1, self, false)
+		}
+	case ast.ListKind:
+		for _, elem := range expr.AsList().Elements() {
+			c.visit(elem, depth+1, self, false)
+		}
+	case ast.MapKind:
+		for _, entry := range expr.AsMap().Entries() {
+			c.visit(entry.AsMapEntry().Key(), depth+1, self, false)
+			c.visit(entry.AsMapEntry().Value(), depth+1, self, false)
+		}
+	case ast.ComprehensionKind:
+		comp := expr.AsComprehension()
+		c.visit(comp.IterRange(), depth+1, self, false)
+		if macro, found := c.parsed.SourceInfo().GetMacroCall(expr.ID()); found && macro.Kind() == ast.CallKind {
+			for _, arg := range macro.AsCall().Args() {
+				// Iteration variable names are not part of the expanded AST and are skipped
+				if argExpr, found := c.byID[arg.ID()]; found && arg.ID() != comp.IterRange().ID() {
+					c.visit(argExpr, depth+1, self, false)
+				}
+			}
+		}
+	}
+}
+
+// coverageFor returns the coverage record of an expression, creating it on first use
+func coverageFor(exprString string) (*expressionCoverage, error) {
+	coverageMu.Lock()
+	defer coverageMu.Unlock()
+
+	if coverage, found := coverageByExpr[exprString]; found {
+		return coverage, nil
+	}
+
+	// Node ids are assigned by the parser, so a macro-tracking parse lines up with the
+	// ids of the program compiled in any environment
+	parsed, err := parseWithMacroCalls(exprString)
+	if err != nil {
+		return nil, err
+	}
+
+	collector := &coverageCollector{parsed: parsed, byID: make(map[int64]ast.Expr)}
+	collector.index(parsed.Expr())
+	collector.visit(parsed.Expr(), 0, -1, false)
+
+	coverage := &expressionCoverage{Expression: exprString, Nodes: collector.nodes}
+	coverageByExpr[exprString] = coverage
+	return coverage, nil
+}
+
+// record adds the sub-expressions evaluated in one run to the coverage counters
+func (c *expressionCoverage) record(state interpreter.EvalState) {
+	coverageMu.Lock()
+	defer coverageMu.Unlock()
+
+	c.Evaluations++
+	evaluated := make([]bool, len(c.Nodes))
+	for i, node := range c.Nodes {
+		value, found := state.Value(node.ID)
+		if !found {
+			// Operands of an evaluated field selection were read as part of it
+			evaluated[i] = node.selectOperand && evaluated[node.parent]
+			if evaluated[i] {
+				node.Hits++
+			}
+			continue
+		}
+
+		evaluated[i] = true
+		node.Hits++
+		switch {
+		case types.IsError(value):
+			node.ErrorHits++
+		case value == types.True:
+			node.TrueHits++
+		case value == types.False:
+			node.FalseHits++
+		}
+	}
+}
+
+// coveredProgram is a state-tracking program that records coverage after each evaluation.
+// The record is looked up per evaluation so that cel_coverage_reset() applies to cached programs.
+type coveredProgram struct {
+	cel.Program
+	expression string
+}
+
+// record adds the evaluation state of one run to the expression's coverage
+func (p *coveredProgram) record(details *cel.EvalDetails) {
+	if details == nil {
+		return
+	}
+	if coverage, err := coverageFor(p.expression); err == nil {
+		coverage.record(details.State())
+	}
+}
+
+func (p *coveredProgram) Eval(input any) (ref.Val, *cel.EvalDetails, error) {
+	out, details, err := p.Program.Eval(input)
+	p.record(details)
+	return out, details, err
+}
+
+func (p *coveredProgram) ContextEval(ctx context.Context, input any) (ref.Val, *cel.EvalDetails, error) {
+	out, details, err := p.Program.ContextEval(ctx, input)
+	p.record(details)
+	return out, details, err
+}
+
+// newProgram creates the program for a checked expression, instrumenting it for
+// coverage when pg_cel.track_coverage is on
+func newProgram(celEnv *cel.Env, checked *cel.Ast, exprString string) (cel.Program, error) {
+	if !coverageEnabled {
+		return celEnv.Program(checked)
+	}
+
+	prg, err := celEnv.Program(checked, cel.EvalOptions(cel.OptTrackState))
+	if err != nil {
+		return nil, err
+	}
+	return &coveredProgram{Program: prg, expression: exprString}, nil
+}
+
+//export pg_cel_set_track_coverage
+func pg_cel_set_track_coverage(enabled C.int) {
+	coverageEnabled = enabled != 0
+}
+
+//export pg_cel_coverage
+func pg_cel_coverage(errorOut **C.char) *C.char {
+	coverageMu.Lock()
+	report := make([]*expressionCoverage, 0, len(coverageByExpr))
+	for _, coverage := range coverageByExpr {
+		report = append(report, coverage)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Expression < report[j].Expression })
+
+	jsonBytes, err := json.Marshal(report)
+	coverageMu.Unlock()
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("Error marshaling coverage: %v", err))
+		return nil
+	}
+
+	return C.CString(string(jsonBytes))
+}
+
+//export pg_cel_coverage_reset
+func pg_cel_coverage_reset() *C.char {
+	coverageMu.Lock()
+	defer coverageMu.Unlock()
+
+	coverageByExpr = make(map[string]*expressionCoverage)
+	return C.CString("Coverage reset successfully")
+}
@@ -0,0 +1,340 @@
+package main
+
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/common/ast"
+)
+
+// parseCELTypeName converts a type name such as "int", "list(string)" or "map(string, dyn)" into a CEL type
+func parseCELTypeName(name string) (*cel.Type, error) {
+	name = strings.TrimSpace(name)
+
+	if inner, ok := strings.CutPrefix(name, "list("); ok && strings.HasSuffix(inner, ")") {
+		elemType, err := parseCELTypeName(strings.TrimSuffix(inner, ")"))
+		if err != nil {
+			return nil, err
+		}
+		return cel.ListType(elemType), nil
+	}
+
+	if inner, ok := strings.CutPrefix(name, "map("); ok && strings.HasSuffix(inner, ")") {
+		inner = strings.TrimSuffix(inner, ")")
+		// Split on the first top-level comma so nested types keep their own commas
+		depth := 0
+		for i, r := range inner {
+			switch r {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			case ',':
+				if depth == 0 {
+					keyType, err := parseCELTypeName(inner[:i])
+					if err != nil {
+						return nil, err
+					}
+					valueType, err := parseCELTypeName(inner[i+1:])
+					if err != nil {
+						return nil, err
+					}
+					return cel.MapType(keyType, valueType), nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("invalid map type %q: expected map(key, value)", name)
+	}
+
+	switch name {
+	case "int":
+		return cel.IntType, nil
+	case "uint":
+		return cel.UintType, nil
+	case "double":
+		return cel.DoubleType, nil
+	case "bool":
+		return cel.BoolType, nil
+	case "string":
+		return cel.StringType, nil
+	case "bytes":
+		return cel.BytesType, nil
+	case "null", "null_type":
+		return cel.NullType, nil
+	case "dyn":
+		return cel.DynType, nil
+	case "timestamp", "google.protobuf.Timestamp":
+		return cel.TimestampType, nil
+	case "duration", "google.protobuf.Duration":
+		return cel.DurationType, nil
+	}
+	return nil, fmt.Errorf("unknown CEL type %q", name)
+}
+
+// parseDeclarations parses a JSON object mapping variable names to CEL type names
+func parseDeclarations(declarationsString string) (map[string]*cel.Type, error) {
+	declarations := make(map[string]*cel.Type)
+	if declarationsString == "" {
+		return declarations, nil
+	}
+
+	var typeNames map[string]string
+	if err := json.Unmarshal([]byte(declarationsString), &typeNames); err != nil {
+		return nil, fmt.Errorf("declarations parsing error: expected an object of variable types: %v", err)
+	}
+
+	for variable, typeName := range typeNames {
+		celType, err := parseCELTypeName(typeName)
+		if err != nil {
+			return nil, fmt.Errorf("declarations parsing error: variable %q: %v", variable, err)
+		}
+		declarations[variable] = celType
+	}
+	return declarations, nil
+}
+
+// createDeclaredCELEnv creates a CEL environment with explicitly declared variables
+func createDeclaredCELEnv(declarations map[string]*cel.Type) (*cel.Env, error) {
+	envOpts := celExtensionOptions()
+	for variable, celType := range declarations {
+		envOpts = append(envOpts, cel.Variable(variable, celType))
+	}
+	return cel.NewEnv(envOpts...)
+}
+
+// explainNode describes one checked AST node
+type explainNode struct {
+	ID        int64           `json:"id"`
+	Kind      string          `json:"kind"`
+	Type      string          `json:"type"`
+	Name      string          `json:"name,omitempty"`
+	Function  string          `json:"function,omitempty"`
+	Overloads []string        `json:"overloads,omitempty"`
+	Field     string          `json:"field,omitempty"`
+	TestOnly  bool            `json:"test_only,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Target    *explainNode    `json:"target,omitempty"`
+	Operand   *explainNode    `json:"operand,omitempty"`
+	Args      []*explainNode  `json:"args,omitempty"`
+	Elements  []*explainNode  `json:"elements,omitempty"`
+	Entries   []*explainEntry `json:"entries,omitempty"`
+
+	// Comprehension parts (macros such as all(), exists() and map() expand to these)
+	IterVar       string       `json:"iter_var,omitempty"`
+	IterRange     *explainNode `json:"iter_range,omitempty"`
+	AccuVar       string       `json:"accu_var,omitempty"`
+	AccuInit      *explainNode `json:"accu_init,omitempty"`
+	LoopCondition *explainNode `json:"loop_condition,omitempty"`
+	LoopStep      *explainNode `json:"loop_step,omitempty"`
+	Result        *explainNode `json:"result,omitempty"`
+}
+
+// explainEntry describes a map literal entry or struct field
+type explainEntry struct {
+	Key   *explainNode `json:"key,omitempty"`
+	Field string       `json:"field,omitempty"`
+	Value *explainNode `json:"value"`
+}
+
+// explainCost is the estimated evaluation cost range; max is null when unbounded
+type explainCost struct {
+	Min uint64  `json:"min"`
+	Max *uint64 `json:"max"`
+}
+
+// explainResult is the cel_explain report
+type explainResult struct {
+	Expression string       `json:"expression"`
+	OutputType string       `json:"output_type"`
+	AST        *explainNode `json:"ast"`
+	Variables  []string     `json:"variables"`
+	Functions  []string     `json:"functions"`
+	Cost       explainCost  `json:"cost"`
+}
+
+// explainer walks a checked AST, collecting node types and references
+type explainer struct {
+	checked      *ast.AST
+	declarations map[string]*cel.Type
+	variables    map[string]bool
+	functions    map[string]bool
+}
+
+// reference records a resolved identifier; comprehension variables are not declared and are skipped
+func (e *explainer) reference(name string) {
+	if _, declared := e.declarations[name]; declared {
+		e.variables[name] = true
+	}
+}
+
+func (e *explainer) node(expr ast.Expr) *explainNode {
+	node := &explainNode{ID: expr.ID(), Type: e.checked.GetType(expr.ID()).String()}
+	reference := e.checked.ReferenceMap()[expr.ID()]
+
+	switch expr.Kind() {
+	case ast.IdentKind:
+		node.Kind = "ident"
+		node.Name = expr.AsIdent()
+		if reference != nil && reference.Value == nil {
+			node.Name = reference.Name
+			e.reference(reference.Name)
+		}
+	case ast.SelectKind:
+		sel := expr.AsSelect()
+		node.Kind = "select"
+		node.Field = sel.FieldName()
+		node.TestOnly = sel.IsTestOnly()
+		node.Operand = e.node(sel.Operand())
+		// Qualified identifiers resolve to a variable on the select node itself
+		if reference != nil && reference.Value == nil && len(reference.OverloadIDs) == 0 {
+			node.Name = reference.Name
+			e.reference(reference.Name)
+		}
+	case ast.CallKind:
+		call := expr.AsCall()
+		node.Kind = "call"
+		node.Function = call.FunctionName()
+		// Internal helpers introduced by macro expansion are not user-visible functions
+		if !strings.HasPrefix(call.FunctionName(), "@") {
+			e.functions[call.FunctionName()] = true
+		}
+		if reference != nil {
+			node.Overloads = reference.OverloadIDs
+		}
+		if call.IsMemberFunction() {
+			node.Target = e.node(call.Target())
+		}
+		for _, arg := range call.Args() {
+			node.Args = append(node.Args, e.node(arg))
+		}
+	case ast.LiteralKind:
+		node.Kind = "literal"
+		if value, err := celValueToJSON(expr.AsLiteral()); err == nil {
+			node.Value = value
+		}
+	case ast.ListKind:
+		node.Kind = "list"
+		for _, elem := range expr.AsList().Elements() {
+			node.Elements = append(node.Elements, e.node(elem))
+		}
+	case ast.MapKind:
+		node.Kind = "map"
+		for _, entry := range expr.AsMap().Entries() {
+			mapEntry := entry.AsMapEntry()
+			node.Entries = append(node.Entries, &explainEntry{Key: e.node(mapEntry.Key()), Value: e.node(mapEntry.Value())})
+		}
+	case ast.StructKind:
+		node.Kind = "struct"
+		node.Name = expr.AsStruct().TypeName()
+		for _, field := range expr.AsStruct().Fields() {
+			structField := field.AsStructField()
+			node.Entries = append(node.Entries, &explainEntry{Field: structField.Name(), Value: e.node(structField.Value())})
+		}
+	case ast.ComprehensionKind:
+		comp := expr.AsComprehension()
+		node.Kind = "comprehension"
+		node.IterVar = comp.IterVar()
+		node.IterRange = e.node(comp.IterRange())
+		node.AccuVar = comp.AccuVar()
+		node.AccuInit = e.node(comp.AccuInit())
+		node.LoopCondition = e.node(comp.LoopCondition())
+		node.LoopStep = e.node(comp.LoopStep())
+		node.Result = e.node(comp.Result())
+	default:
+		node.Kind = "unspecified"
+	}
+	return node
+}
+
+// sortedKeys returns the keys of a set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// explainUnknownSizes lets cost estimation proceed without size hints for variables
+type explainUnknownSizes struct{}
+
+func (explainUnknownSizes) EstimateSize(element checker.AstNode) *checker.SizeEstimate {
+	return nil
+}
+
+func (explainUnknownSizes) EstimateCallCost(function, overloadID string, target *checker.AstNode, args []checker.AstNode) *checker.CallEstimate {
+	return nil
+}
+
+// explainExpression compiles an expression against declared variables and describes the checked AST
+func explainExpression(exprString string, declarations map[string]*cel.Type) (*explainResult, error) {
+	celEnv, err := createDeclaredCELEnv(declarations)
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %v", err)
+	}
+
+	checkedAst, issues := celEnv.Compile(exprString)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("CEL compilation error: %v", issues.Err())
+	}
+
+	cost, err := celEnv.EstimateCost(checkedAst, explainUnknownSizes{})
+	if err != nil {
+		return nil, fmt.Errorf("CEL cost estimation error: %v", err)
+	}
+
+	walker := &explainer{
+		checked:      checkedAst.NativeRep(),
+		declarations: declarations,
+		variables:    make(map[string]bool),
+		functions:    make(map[string]bool),
+	}
+	result := &explainResult{
+		Expression: exprString,
+		OutputType: checkedAst.OutputType().String(),
+		AST:        walker.node(checkedAst.NativeRep().Expr()),
+		Cost:       explainCost{Min: cost.Min},
+	}
+	result.Variables = sortedKeys(walker.variables)
+	result.Functions = sortedKeys(walker.functions)
+	if cost.Max != math.MaxUint64 {
+		result.Cost.Max = &cost.Max
+	}
+
+	return result, nil
+}
+
+//export pg_cel_explain
+func pg_cel_explain(expressionStr *C.char, declarationsStr *C.char, errorOut **C.char) *C.char {
+	// Convert C strings to Go strings
+	exprString := C.GoString(expressionStr)
+	declarationsString := C.GoString(declarationsStr)
+
+	declarations, err := parseDeclarations(declarationsString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	result, err := explainExpression(exprString, declarations)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("Error marshaling explanation: %v", err))
+		return nil
+	}
+
+	return C.CString(string(jsonBytes))
+}
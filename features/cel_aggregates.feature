@@ -0,0 +1,62 @@
+Feature: CEL Aggregate Functions
+  In order to express custom reductions in the same language as my filters
+  As a data analyst
+  I need aggregates whose accumulator logic is written in CEL
+
+  Background:
+    Given pg-cel extension is loaded
+    And I have a test table with data
+
+  Scenario: Fold documents into a sum
+    When I execute SQL:
+      """
+      SELECT cel_agg_fold('0.0', 'acc + score', to_jsonb(s))::text AS total
+      FROM test_scores s;
+      """
+    Then the SQL result should be "440"
+
+  Scenario: Fold into a structured accumulator
+    When I execute SQL:
+      """
+      SELECT cel_agg_fold('{"count": 0, "oldest": 0.0}',
+                          '{"count": acc.count + 1, "oldest": math.greatest(acc.oldest, age)}',
+                          to_jsonb(u)) ->> 'oldest' AS oldest
+      FROM test_users u;
+      """
+    Then the SQL result should be "35"
+
+  Scenario: Count documents matching an expression per group
+    When I execute SQL:
+      """
+      SELECT string_agg(bucket || ':' || adults, ',' ORDER BY bucket) AS counts
+      FROM (
+        SELECT age / 10 AS bucket, cel_count_if('age >= 28.0', to_jsonb(u)) AS adults
+        FROM test_users u
+        GROUP BY age / 10
+      ) g;
+      """
+    Then the SQL result should be "2:1,3:2"
+
+  Scenario: Count over no rows is zero
+    When I execute SQL:
+      """
+      SELECT cel_count_if('true', to_jsonb(u)) AS matched
+      FROM test_users u
+      WHERE false;
+      """
+    Then the SQL result should be "0"
+
+  Scenario: Step errors abort the aggregate
+    When I execute SQL:
+      """
+      SELECT cel_agg_fold('0.0', 'acc + missing', to_jsonb(s)) FROM test_scores s;
+      """
+    Then I should receive an error
+
+  Scenario: A document key named like the accumulator is rejected
+    When I execute SQL:
+      """
+      SELECT cel_agg_fold('0.0', 'acc + 1.0', d) FROM (VALUES ('{"acc": 5}'::jsonb)) AS t(d);
+      """
+    Then I should receive an error
+    And the error message should contain "conflicts with the accumulator"
@@ -0,0 +1,169 @@
+Feature: CEL Cache Monitoring
+  In order to see which expressions dominate CPU time
+  As a database administrator
+  I need per-cache statistics and a profile of the most frequently evaluated expressions
+
+  Background:
+    Given pg-cel extension is loaded
+
+  Scenario: Cache statistics have one row per cache
+    When I execute SQL:
+      """
+      SELECT string_agg(cache, ',' ORDER BY cache) AS caches FROM cel_cache_statistics();
+      """
+    Then the SQL result should be "json,program"
+
+  Scenario: Program cache statistics count hits, misses and entries
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT s.hits || ',' || s.misses || ',' || s.entries || ',' || (s.bytes > 0) || ',' || s.hit_ratio AS stats
+      FROM (SELECT count(cel_eval_json('x + 1.0', '{"x": ' || g || '}')) AS n FROM generate_series(1, 4) AS g) AS e
+      CROSS JOIN LATERAL (SELECT * FROM cel_cache_statistics() WHERE cache = 'program' AND e.n > 0) AS s;
+      """
+    Then the SQL result should be "3,1,1,true,0.75"
+
+  Scenario: Hot expressions are ordered by evaluations
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT h.expression || ',' || h.evaluations || ',' || h.cache_hits || ',' || h.compilations AS hottest
+      FROM (SELECT count(cel_eval_json('x + 1.0', '{"x": ' || g || '}')) +
+                   count(cel_eval_json('x > 0.0', '{"x": ' || g || '}')) FILTER (WHERE g = 1) AS n
+            FROM generate_series(1, 3) AS g) AS e
+      CROSS JOIN LATERAL (SELECT * FROM cel_hot_expressions(1) WHERE e.n > 0) AS h;
+      """
+    Then the SQL result should be "x + 1.0,3,2,1"
+
+  Scenario: Hot expressions report compile and evaluation times
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT bool_and(h.environment = 'document' AND h.compile_time_ms > 0 AND h.avg_eval_time_us > 0
+                      AND h.total_eval_time_ms >= h.avg_eval_time_us / 1000) AS timed
+      FROM (SELECT count(cel_eval_json('items.exists(i, i > 2.0)', '{"items": [1, 2, ' || g || ']}')) AS n
+            FROM generate_series(1, 5) AS g) AS e
+      CROSS JOIN LATERAL (SELECT * FROM cel_hot_expressions() WHERE e.n > 0) AS h;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Warming compiles every valid expression
+    When I execute SQL:
+      """
+      SELECT cel_cache_warm(ARRAY['age >= 18.0', 'name.startsWith("A")', 'age >='], '{"age": "double", "name": "string"}') AS warmed;
+      """
+    Then the SQL result should be "2"
+
+  Scenario: Warmed programs serve the first evaluation from cache
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT e.result || ',' || h.compilations || ',' || h.cache_hits AS warm
+      FROM (SELECT cel_cache_warm(ARRAY['age >= 18.0 && name != ""'], '{"age": "double", "name": "string"}') AS n) AS w
+      CROSS JOIN LATERAL (SELECT cel_eval_json('age >= 18.0 && name != ""', format('{"age": %s, "name": "Ada"}', 19 + w.n)) AS result) AS e
+      CROSS JOIN LATERAL (SELECT * FROM cel_hot_expressions() WHERE e.result IS NOT NULL) AS h;
+      """
+    Then the SQL result should be "true,1,1"
+
+  Scenario: Warming binds declared numbers and containers the way documents do
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT e.result || ',' || h.compilations || ',' || h.cache_hits AS warm
+      FROM (SELECT cel_cache_warm(ARRAY['age >= 18.0 && tags.size() > 0'], '{"age": "int", "tags": "list(string)"}') AS n) AS w
+      CROSS JOIN LATERAL (SELECT cel_eval_json('age >= 18.0 && tags.size() > 0', format('{"age": %s, "tags": ["a"]}', 19 + w.n)) AS result) AS e
+      CROSS JOIN LATERAL (SELECT * FROM cel_hot_expressions() WHERE e.result IS NOT NULL) AS h;
+      """
+    Then the SQL result should be "true,1,1"
+
+  Scenario: Warming rejects types documents never bind
+    When I execute SQL:
+      """
+      SELECT cel_cache_warm(ARRAY['size(payload) > 0'], '{"payload": "bytes"}');
+      """
+    Then I should receive an error
+    And the error message should contain "documents never bind type bytes"
+
+  Scenario: The warm query runs before the statement that loads pg-cel
+    Given I start a new database session
+    And the session setting "pg_cel.warm_query" is "SELECT 'age >= 18.0', json_build_object('age', 'double')::text"
+    When I execute SQL:
+      """
+      SELECT e.result || ',' || h.compilations || ',' || h.cache_hits AS warm
+      FROM (SELECT cel_eval_json('age >= 18.0', format('{"age": %s}', 19 + g)) AS result FROM generate_series(0, 0) AS g) AS e
+      CROSS JOIN LATERAL (SELECT * FROM cel_hot_expressions() WHERE e.result IS NOT NULL) AS h;
+      """
+    Then the SQL result should be "true,1,1"
+
+  Scenario: Warming rejects unknown declaration types
+    When I execute SQL:
+      """
+      SELECT cel_cache_warm(ARRAY['x > 1'], '{"x": "number"}');
+      """
+    Then I should receive an error
+    And the error message should contain "declarations parsing error"
+
+  Scenario: pg_stat_cel exposes cluster-wide counters
+    When I execute SQL:
+      """
+      SELECT string_agg(column_name, ',' ORDER BY ordinal_position) AS columns
+      FROM information_schema.columns
+      WHERE table_name = 'pg_stat_cel';
+      """
+    Then the SQL result should be "program_hits,compilations,compile_time_ms,json_hits,json_misses,evaluations,evaluation_errors,eval_time_ms,stats_reset"
+
+  @preloaded
+  Scenario: pg_stat_cel sums the evaluations of committed transactions
+    When I execute SQL:
+      """
+      SELECT pg_stat_cel_reset();
+      """
+    And I execute SQL:
+      """
+      SELECT count(cel_eval_json('x + 1.0', '{"x": ' || g || '}')) + count(cel_eval_json('_doc["missing"]', '{"x": ' || g || '}')) FILTER (WHERE g = 1) AS n
+      FROM generate_series(1, 3) AS g;
+      """
+    And I execute SQL:
+      """
+      SELECT evaluations || ',' || evaluation_errors || ',' || (eval_time_ms > 0) AS counted FROM pg_stat_cel;
+      """
+    Then the SQL result should be "4,1,true"
+
+  @preloaded
+  Scenario: pg_stat_cel includes the evaluations of the current transaction
+    When I execute SQL:
+      """
+      SELECT s.evaluations || ',' || (s.compilations + s.program_hits) || ',' || (s.json_hits + s.json_misses) AS counted
+      FROM (SELECT pg_stat_cel_reset()::text AS reset) AS r
+      CROSS JOIN LATERAL (SELECT count(cel_eval_json('x > 1.0', '{"x": ' || g || '}' || r.reset)) AS n
+                          FROM generate_series(1, 3) AS g) AS e
+      CROSS JOIN LATERAL (SELECT * FROM pg_stat_cel WHERE e.n = 3) AS s;
+      """
+    Then the SQL result should be "3,3,3"
+
+  @preloaded
+  Scenario: Resetting pg_stat_cel zeroes the counters and records the time
+    When I execute SQL:
+      """
+      SELECT (s.evaluations = 0 AND s.stats_reset >= now())::text AS reset
+      FROM (SELECT cel_eval_json('1 + 1', '{}') AS result) AS e
+      CROSS JOIN LATERAL (SELECT pg_stat_cel_reset()::text AS reset WHERE e.result = '2') AS r
+      CROSS JOIN LATERAL (SELECT * FROM pg_stat_cel WHERE r.reset = '') AS s;
+      """
+    Then the SQL result should be "true"
+
+  @not_preloaded
+  Scenario: pg_stat_cel requires pg_cel_stats to be preloaded
+    When I execute SQL:
+      """
+      SELECT * FROM pg_stat_cel;
+      """
+    Then I should receive an error
+    And the error message should contain "pg_cel_stats must be loaded via shared_preload_libraries"
+
+  Scenario: Resetting pg_stat_cel is not granted to ordinary roles
+    When I execute SQL:
+      """
+      SELECT has_function_privilege('pg_read_all_stats', 'pg_stat_cel_reset()', 'EXECUTE') AS granted;
+      """
+    Then the SQL result should be "false"
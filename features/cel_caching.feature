@@ -47,6 +47,151 @@ Feature: CEL Caching Performance
     Then each combination should have a unique cache key
     And cache hits should only occur for identical expression+data pairs
 
+  Scenario: Cached programs are not reused for documents with different variable types
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('x + x', '{"x": 1}') || ',' || cel_eval_json('x + x', '{"x": "a"}') AS results;
+      """
+    Then the SQL result should be "2,aa"
+
+  Scenario: JSON documents are cached when seen for the second time
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT string_agg(cel_eval_json('x', doc), ',') || ';' ||
+             (cel_cache_stats()::jsonb->>'json_hits') || ',' || (cel_cache_stats()::jsonb->>'json_skipped_unseen') AS results
+      FROM (VALUES ('{"x": 1}'), ('{"x": 1}'), ('{"x": 1}')) AS docs(doc);
+      """
+    Then the SQL result should be "1,1,1;1,1"
+
+  Scenario: JSON documents over the size ceiling are not cached
+    Given the session setting "pg_cel.json_cache_max_entry_kb" is "1"
+    And the cache is cleared
+    When I execute SQL:
+      """
+      SELECT count(cel_eval_json('x.size()', '{"x": "' || filler || '"}')) || ',' ||
+             (cel_cache_stats()::jsonb->>'json_hits') || ',' || (cel_cache_stats()::jsonb->>'json_skipped_oversize') AS results
+      FROM (VALUES (repeat('a', 2000)), (repeat('a', 2000)), (repeat('a', 2000))) AS docs(filler);
+      """
+    Then the SQL result should be "3,0,3"
+
+  Scenario: The JSON cache can be disabled
+    Given the session setting "pg_cel.enable_json_cache" is "off"
+    And the cache is cleared
+    When I execute SQL:
+      """
+      SELECT count(cel_eval_json('x', doc)) || ',' || (cel_cache_stats()::jsonb->>'json_hits') AS results
+      FROM (VALUES ('{"x": 1}'), ('{"x": 1}'), ('{"x": 1}')) AS docs(doc);
+      """
+    Then the SQL result should be "3,0"
+
+  Scenario: Cache costs are estimated in bytes
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT count(cel_eval_json('items.size() > 2', doc)) || ',' ||
+             ((cel_cache_stats()::jsonb->>'program_cost_added')::bigint > 65536) || ',' ||
+             ((cel_cache_stats()::jsonb->>'json_cost_added')::bigint BETWEEN 300 AND 4096) AS results
+      FROM (VALUES ('{"items": ["a", "b", "c"]}'), ('{"items": ["a", "b", "c"]}')) AS docs(doc);
+      """
+    Then the SQL result should be "2,true,true"
+
+  Scenario: Programs are not shared between environments
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT cel_eval('optional.of(1).hasValue()') || ',' || cel_eval_json('optional.of(1).hasValue()', '{}') AS results;
+      """
+    Then I should receive an error
+    And the error message should contain "undeclared reference to 'optional'"
+
+  Scenario: Invalidating an expression drops it for every document shape
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('x == x', '{"x": 1}') || ',' || cel_eval_json('x == x', '{"x": "a"}') || ',' ||
+             cel_cache_invalidate('x == x') || ',' || cel_cache_invalidate('x == x') AS results;
+      """
+    Then the SQL result should be "true,true,2,0"
+
+  Scenario: Invalidating by prefix drops every matching expression
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('x > 1.0', '{"x": 2}') || ',' || cel_eval_json('x > 2.0', '{"x": 2}') || ',' ||
+             cel_eval_json('y > 1.0', '{"y": 2}') || ',' || cel_cache_invalidate_prefix('x >') AS results;
+      """
+    Then the SQL result should be "true,false,true,2"
+
+  Scenario: Invalidating by environment leaves other environments cached
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT cel_eval('1 + 1') || ',' || cel_eval_json('1 + 1', '{}') || ',' ||
+             cel_cache_invalidate_environment('static') || ',' || cel_cache_invalidate_environment('document') AS results;
+      """
+    Then the SQL result should be "2,2,1,1"
+
+  Scenario: Invalidating an unknown environment is an error
+    When I execute SQL:
+      """
+      SELECT cel_cache_invalidate_environment('nonexistent');
+      """
+    Then I should receive an error
+    And the error message should contain "unknown CEL environment"
+
+  Scenario: Cached JSON documents expire after the TTL
+    Given the session setting "pg_cel.json_cache_ttl" is "1"
+    And the cache is cleared
+    When I execute SQL:
+      """
+      SELECT again.result || ',' || (cel_cache_stats()::jsonb->>'json_hits') AS results
+      FROM (SELECT count(cel_eval_json('x', doc)) AS n FROM (VALUES ('{"x": 1}'), ('{"x": 1}')) AS docs(doc)) AS seen
+      CROSS JOIN LATERAL (SELECT pg_sleep(2)::text AS slept WHERE seen.n = 2) AS pause
+      CROSS JOIN LATERAL (SELECT cel_eval_json('x', format('{"x": 1}%s', pause.slept)) AS result) AS again;
+      """
+    Then the SQL result should be "1,0"
+
+  Scenario: Cache sizes can be changed in a running session
+    Given the session setting "pg_cel.json_cache_size_mb" is "128"
+    When I execute SQL:
+      """
+      SELECT max_bytes FROM cel_cache_statistics() WHERE cache = 'json';
+      """
+    Then the SQL result should be "134217728"
+
+  Scenario: Resizing a cache keeps its entries
+    Given the cache is cleared
+    When I execute SQL:
+      """
+      SELECT s.entries || ',' || s.max_bytes AS results
+      FROM (SELECT cel_eval_json('x + 1.0', '{"x": 1}') AS result) AS e
+      CROSS JOIN LATERAL (SELECT set_config('pg_cel.program_cache_size_mb', '96', false) AS size WHERE e.result = '2') AS resized
+      CROSS JOIN LATERAL (SELECT * FROM cel_cache_statistics() WHERE cache = 'program' AND resized.size = '96') AS s;
+      """
+    Then the SQL result should be "1,100663296"
+
+  Scenario: Shrinking the program cache evicts down to the new size
+    Given the session setting "pg_cel.program_cache_size_mb" is "128"
+    And the cache is cleared
+    When I execute SQL:
+      """
+      SELECT count(cel_eval_json('x + ' || g || '.0', '{"x": 1}')) AS compiled FROM generate_series(1, 1000) AS g;
+      """
+    And I execute SQL:
+      """
+      SELECT set_config('pg_cel.program_cache_size_mb', '64', false) AS size;
+      """
+    And I execute SQL:
+      """
+      SELECT (s.bytes <= s.max_bytes) || ',' || (s.entries < 1000) || ',' || s.max_bytes || ',' || h.compilations AS shrunk
+      FROM cel_cache_statistics() AS s
+      CROSS JOIN (SELECT sum(compilations) AS compilations FROM cel_hot_expressions(1000)) AS h
+      WHERE s.cache = 'program';
+      """
+    Then the SQL result should be "true,true,67108864,1000"
+
   Scenario: Cache persistence across sessions
     Given I evaluate CEL expression "test_expression"
     When I start a new database session
@@ -0,0 +1,63 @@
+Feature: Precompiled CEL Expressions
+  In order to avoid recompiling stored rules in every new backend
+  As a database user
+  I need to store type-checked expressions as bytea and evaluate them directly
+
+  Background:
+    Given pg-cel extension is loaded
+
+  Scenario: Compiled expressions evaluate against documents
+    When I execute SQL:
+      """
+      SELECT cel_eval_compiled(cel_compile('age >= 18 && country in ["DE", "FR"]', '{"age": "int", "country": "string"}'),
+                               '{"age": 21, "country": "DE"}')::text AS result;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Compiled expressions return typed jsonb results
+    When I execute SQL:
+      """
+      SELECT cel_eval_compiled(cel_compile('{"total": price * qty}', '{"price": "double", "qty": "double"}'),
+                               '{"price": 2.5, "qty": 4}') ->> 'total' AS total;
+      """
+    Then the SQL result should be "10"
+
+  Scenario: Compiled expressions survive a round trip through a table
+    When I execute SQL:
+      """
+      WITH stored AS (
+          SELECT cel_compile('tags.exists(t, t == "vip")', '{"tags": "list(string)"}') AS compiled
+      )
+      SELECT cel_eval_compiled(compiled, '{"tags": ["new", "vip"]}')::text AS result FROM stored;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Compilation type-checks against the declarations
+    When I execute SQL:
+      """
+      SELECT cel_compile('name + 1', '{"name": "string"}');
+      """
+    Then I should receive an error
+    And the error message should contain "CEL compilation error"
+
+  Scenario: Invalid compiled data is rejected
+    When I execute SQL:
+      """
+      SELECT cel_eval_compiled('\xdeadbeef'::bytea, '{}');
+      """
+    Then I should receive an error
+    And the error message should contain "compiled expression decoding error"
+
+  Scenario Outline: Malformed checked expressions are rejected instead of planned
+    When I execute SQL:
+      """
+      SELECT cel_eval_compiled(decode('<compiled>', 'hex'), '{}');
+      """
+    Then I should receive an error
+    And the error message should contain "not a valid checked CEL expression"
+
+    Examples:
+      | compiled                                                                  |
+      | 120c080112081a06657175616c731a06080112021801220a1001320612045f3d3d5f      |
+      | 120c080112081a06657175616c731a06080112021801220a1001320612045f5b5f5d      |
+      | 120c080112081a06657175616c731a06080112021801220b1001320712055f3f5f3a5f    |
@@ -0,0 +1,73 @@
+Feature: CEL Expression Coverage
+  In order to find dead or untested parts of long rules
+  As a database administrator
+  I need to record which sub-expressions of each program are evaluated over a workload
+
+  Background:
+    Given pg-cel extension is loaded
+
+  Scenario: Coverage is not recorded unless enabled
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('coverage_off && true', '{"coverage_off": true}');
+      """
+    And I execute SQL:
+      """
+      SELECT count(*) AS covered FROM cel_coverage() WHERE expression = 'coverage_off && true';
+      """
+    Then the SQL result should be "0"
+
+  Scenario: Short-circuited operands are reported as never evaluated
+    Given the session setting "pg_cel.track_coverage" is "on"
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('eligible && verified', '{"eligible": false, "verified": true}');
+      """
+    And I execute SQL:
+      """
+      SELECT string_agg(sub_expression || '=' || hits, ', ' ORDER BY step) AS coverage
+      FROM cel_coverage() WHERE expression = 'eligible && verified';
+      """
+    Then the SQL result should be "eligible && verified=1, eligible=1, verified=0"
+
+  Scenario: Coverage counts true and false outcomes across evaluations
+    Given the session setting "pg_cel.track_coverage" is "on"
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('score > 10.0', data)
+      FROM (VALUES ('{"score": 5}'), ('{"score": 20}'), ('{"score": 30}')) AS docs(data);
+      """
+    And I execute SQL:
+      """
+      SELECT evaluations || ':' || true_hits || ':' || false_hits AS outcomes
+      FROM cel_coverage() WHERE expression = 'score > 10.0' AND depth = 0;
+      """
+    Then the SQL result should be "3:2:1"
+
+  Scenario: Predicates of macros that never iterate are uncovered
+    Given the session setting "pg_cel.track_coverage" is "on"
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('items.exists(i, i > 3.0)', '{"items": []}');
+      """
+    And I execute SQL:
+      """
+      SELECT hits FROM cel_coverage() WHERE expression = 'items.exists(i, i > 3.0)' AND sub_expression = 'i > 3.0';
+      """
+    Then the SQL result should be "0"
+
+  Scenario: Coverage can be reset
+    Given the session setting "pg_cel.track_coverage" is "on"
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('reset_me || false', '{"reset_me": true}');
+      """
+    And I execute SQL:
+      """
+      SELECT cel_coverage_reset();
+      """
+    And I execute SQL:
+      """
+      SELECT count(*) AS covered FROM cel_coverage();
+      """
+    Then the SQL result should be "0"
@@ -0,0 +1,96 @@
+Feature: Single-Variable Document Binding
+  In order to serve documents with varying keys from one compiled program
+  As a database user
+  I need to bind the whole JSON document to a single CEL variable
+
+  Background:
+    Given pg-cel extension is loaded
+
+  Scenario: Object documents are bound to the document variable
+    Given the session setting "pg_cel.document_variable" is "doc"
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('has(doc.discount) ? doc.price - doc.discount : doc.price', '{"price": 10, "discount": 3}') AS total;
+      """
+    Then the SQL result should be "7"
+
+  Scenario: Documents with different keys share one expression
+    Given the session setting "pg_cel.document_variable" is "doc"
+    When I execute SQL:
+      """
+      SELECT string_agg(cel_eval_json('has(doc.discount) ? doc.price - doc.discount : doc.price', data), ',' ORDER BY data) AS totals
+      FROM (VALUES ('{"price": 10}'), ('{"price": 10, "discount": 3}'), ('{"price": 10, "tax": 2}')) AS docs(data);
+      """
+    Then the SQL result should be "10,7,10"
+
+  Scenario: Array roots are accepted
+    Given the session setting "pg_cel.document_variable" is "doc"
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('doc.filter(x, x > 1.0).size()', '[1, 2, 3]') AS result;
+      """
+    Then the SQL result should be "2"
+
+  Scenario: Scalar roots are accepted
+    Given the session setting "pg_cel.document_variable" is "this"
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('this.startsWith("ab")', '"abc"') AS result;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Rules see the document variable
+    Given the session setting "pg_cel.document_variable" is "doc"
+    When I execute SQL:
+      """
+      SELECT string_agg(rule_id, ',' ORDER BY rule_id) AS matched
+      FROM cel_eval_rules('[5, 20]'::jsonb, '{"any_large": "doc.exists(x, x > 10.0)", "all_large": "doc.all(x, x > 10.0)"}'::jsonb);
+      """
+    Then the SQL result should be "any_large"
+
+  Scenario: The document variable must be a CEL identifier
+    When I execute SQL:
+      """
+      SELECT set_config('pg_cel.document_variable', 'in', false);
+      """
+    Then I should receive an error
+    And the error message should contain "invalid value for parameter"
+
+  Scenario: Keys that are not CEL identifiers do not break evaluation
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('last', '{"first-name": "Ada", "last": "Lovelace", "in": 1, "1st": true, "@type": "Person"}') AS result;
+      """
+    Then the SQL result should be "Lovelace"
+
+  Scenario: Keys that are not CEL identifiers are reachable through the root variable
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('_doc["first-name"] + " " + _doc["@type"]', '{"first-name": "Ada", "@type": "Person"}') AS result;
+      """
+    Then the SQL result should be "Ada Person"
+
+  Scenario: Reserved-word keys are reachable through the root variable
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('_doc["in"] + _doc["1st"]', '{"in": 2, "1st": 3}') AS result;
+      """
+    Then the SQL result should be "5"
+
+  Scenario: A top-level key named like the root variable takes precedence
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('_doc + 1.0', '{"_doc": 41}') AS result;
+      """
+    Then the SQL result should be "42"
+
+  Scenario: Functions evaluating documents are not immutable
+    When I execute SQL:
+      """
+      SELECT count(*) FILTER (WHERE p.provolatile = 'i') || '/' || count(*) AS immutable
+      FROM pg_proc p
+      WHERE p.proname IN ('cel_eval_json', 'cel_eval_bool', 'cel_eval_rules_json', 'cel_decide_json',
+                          'cel_agg_fold_transfn', 'cel_count_if_transfn', 'cel_validate_json',
+                          'cel_transform_json', 'cel_project_json', 'cel_eval_trace_json');
+      """
+    Then the SQL result should be "0/12"
@@ -0,0 +1,78 @@
+Feature: CEL Expression Data Type
+  In order to guarantee that rule columns only hold parseable CEL
+  As a database user
+  I need a celexpr type that validates and normalizes expressions on input
+
+  Background:
+    Given pg-cel extension is loaded
+
+  Scenario: Input is stored in canonical form
+    When I execute SQL:
+      """
+      SELECT 'age>=18.0&&(verified)'::celexpr::text AS rule;
+      """
+    Then the SQL result should be "age >= 18.0 && verified"
+
+  Scenario: Invalid expressions are rejected on input
+    When I execute SQL:
+      """
+      SELECT 'age >='::celexpr;
+      """
+    Then I should receive an error
+    And the error message should contain "invalid input syntax for type celexpr"
+
+  Scenario: Text is validated when converted to celexpr
+    When I execute SQL:
+      """
+      SELECT CAST('items.all(i, ' || 'i > 0' AS celexpr);
+      """
+    Then I should receive an error
+    And the error message should contain "invalid input syntax for type celexpr"
+
+  Scenario: Equivalent expressions compare equal
+    When I execute SQL:
+      """
+      SELECT ('x>1 && y'::celexpr = '(x > 1) && y'::celexpr)::text || ',' ||
+             ('x > 1'::celexpr <> 'x >= 1'::celexpr)::text AS comparison;
+      """
+    Then the SQL result should be "true,true"
+
+  Scenario: celexpr values are accepted by text functions
+    When I execute SQL:
+      """
+      SELECT cel_eval_json('score * 2.0'::celexpr, '{"score": 21}') AS result;
+      """
+    Then the SQL result should be "42"
+
+  Scenario: Equivalent expressions are deduplicated by DISTINCT and GROUP BY
+    When I execute SQL:
+      """
+      SELECT d.n || ',' || g.n AS groups
+      FROM (SELECT count(DISTINCT r) AS n FROM (VALUES ('x>1'::celexpr), ('(x > 1)'), ('y'), ('( y )')) AS v(r)) AS d,
+           (SELECT count(*) AS n FROM (SELECT r FROM (VALUES ('x>1'::celexpr), ('(x > 1)'), ('y')) AS v(r) GROUP BY r) AS grouped) AS g;
+      """
+    Then the SQL result should be "2,2"
+
+  Scenario: celexpr values sort by their canonical form
+    When I execute SQL:
+      """
+      SELECT string_agg(r::text, ';' ORDER BY r) AS sorted
+      FROM (VALUES ('b'::celexpr), ('a>1'), ('a  >  0')) AS v(r);
+      """
+    Then the SQL result should be "a > 0;a > 1;b"
+
+  Scenario: celexpr has default btree and hash operator classes
+    When I execute SQL:
+      """
+      SELECT string_agg(am.amname, ',' ORDER BY am.amname) AS methods
+      FROM pg_opclass c JOIN pg_am am ON am.oid = c.opcmethod
+      WHERE c.opcintype = 'celexpr'::regtype AND c.opcdefault;
+      """
+    Then the SQL result should be "btree,hash"
+
+  Scenario: Binary output sends the canonical text
+    When I execute SQL:
+      """
+      SELECT convert_from(celexpr_send('x>1&&y'::celexpr), 'UTF8') AS sent;
+      """
+    Then the SQL result should be "x > 1 && y"
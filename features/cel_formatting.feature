@@ -0,0 +1,50 @@
+Feature: CEL Expression Formatting
+  In order to diff, deduplicate and review stored rules
+  As a database user
+  I need a canonical, optionally multi-line form of CEL expressions
+
+  Background:
+    Given pg-cel extension is loaded
+
+  Scenario: Formatting normalizes spacing and parentheses
+    When I execute SQL:
+      """
+      SELECT cel_format('a&&(b||c)   &&  (d)') AS formatted;
+      """
+    Then the SQL result should be "a && (b || c) && d"
+
+  Scenario: Formatting keeps macros as written
+    When I execute SQL:
+      """
+      SELECT cel_format('items.exists(i,i.price>10.0)') AS formatted;
+      """
+    Then the SQL result should be "items.exists(i, i.price > 10.0)"
+
+  Scenario: Differently written rules share a canonical form
+    When I execute SQL:
+      """
+      SELECT cel_format('x>1&&y') = cel_format('(x > 1) && y // same rule') AS same;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Formatting is idempotent
+    When I execute SQL:
+      """
+      SELECT cel_format(cel_format('[1,2,3].map(v,v*2)')) = cel_format('[1,2,3].map(v,v*2)') AS stable;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Long expressions wrap before logical operators
+    When I execute SQL:
+      """
+      SELECT position(E'\n&& ' IN cel_format('user.age >= 18 && user.country in ["DE", "FR"] && user.verified', 30)) > 0 AS wrapped;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Formatting rejects invalid expressions
+    When I execute SQL:
+      """
+      SELECT cel_format('a &&');
+      """
+    Then I should receive an error
+    And the error message should contain "CEL parse error"
@@ -0,0 +1,100 @@
+Feature: CEL Expression Introspection
+  In order to understand and review CEL expressions before deploying them
+  As a database user
+  I need to see the checked AST, referenced variables and functions, and estimated cost
+
+  Background:
+    Given pg-cel extension is loaded
+
+  Scenario: Explain reports the output type and the root node
+    When I execute SQL:
+      """
+      SELECT (cel_explain('x + 1 > 2', '{"x": "int"}') ->> 'output_type') || ':' ||
+             (cel_explain('x + 1 > 2', '{"x": "int"}') #>> '{ast,function}') AS root;
+      """
+    Then the SQL result should be "bool:_>_"
+
+  Scenario: Explain lists referenced variables
+    When I execute SQL:
+      """
+      SELECT cel_explain('user.age >= min_age', '{"user": "map(string, dyn)", "min_age": "int"}')
+             -> 'variables' = '["min_age", "user"]'::jsonb AS matches;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Explain skips comprehension variables
+    When I execute SQL:
+      """
+      SELECT cel_explain('tags.exists(t, t == "vip")', '{"tags": "list(string)"}') ->> 'variables' = '["tags"]' AS matches;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Explain lists functions with resolved overloads
+    When I execute SQL:
+      """
+      SELECT cel_explain('name.size()', '{"name": "string"}') #>> '{ast,overloads,0}' AS overload;
+      """
+    Then the SQL result should be "string_size"
+
+  Scenario: Explain estimates a bounded cost
+    When I execute SQL:
+      """
+      SELECT (cel_explain('1 + 2') -> 'cost' ->> 'min') || '-' || (cel_explain('1 + 2') -> 'cost' ->> 'max') AS cost;
+      """
+    Then the SQL result should be "1-1"
+
+  Scenario: Explain reports unbounded cost as null
+    When I execute SQL:
+      """
+      SELECT cel_explain('tags.exists(t, t == "vip")', '{"tags": "list(string)"}') -> 'cost' -> 'max' = 'null'::jsonb AS unbounded;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Explain rejects undeclared variables
+    When I execute SQL:
+      """
+      SELECT cel_explain('missing > 1', '{}');
+      """
+    Then I should receive an error
+    And the error message should contain "undeclared reference"
+
+  Scenario: Explain rejects unknown declaration types
+    When I execute SQL:
+      """
+      SELECT cel_explain('x', '{"x": "integer"}');
+      """
+    Then I should receive an error
+    And the error message should contain "unknown CEL type"
+
+  Scenario: Trace shows why a compound condition is false
+    When I execute SQL:
+      """
+      SELECT string_agg(sub_expression || '=' || value::text, ', ' ORDER BY step) AS trace
+      FROM cel_eval_trace('a && (b || c)', '{"a": true, "b": false, "c": false}');
+      """
+    Then the SQL result should be "a && (b || c)=false, a=true, b || c=false, b=false, c=false"
+
+  Scenario: Trace reports nesting depth
+    When I execute SQL:
+      """
+      SELECT string_agg(depth::text, ',' ORDER BY step) AS depths
+      FROM cel_eval_trace('a && (b || c)', '{"a": true, "b": false, "c": false}');
+      """
+    Then the SQL result should be "0,1,1,2,2"
+
+  Scenario: Trace records errors of sub-expressions
+    When I execute SQL:
+      """
+      SELECT error FROM cel_eval_trace('has(user.email) || user.email != ""', '{"user": {}}')
+      WHERE sub_expression = 'user.email'
+      LIMIT 1;
+      """
+    Then the SQL result should be "no such key: email"
+
+  Scenario: Trace rejects expressions that do not compile
+    When I execute SQL:
+      """
+      SELECT * FROM cel_eval_trace('a +', '{"a": 1}');
+      """
+    Then I should receive an error
+    And the error message should contain "CEL compilation error"
@@ -0,0 +1,60 @@
+Feature: CEL Row-Level Security Policies
+  In order to enforce the same authorization rules in the database as in my services
+  As a security engineer
+  I need row-level security policies written as CEL expressions
+
+  Background:
+    Given pg-cel extension is loaded
+
+  Scenario: Policy compares the row with the current user
+    When I execute SQL:
+      """
+      SELECT cel_policy('row.owner == principal.user', jsonb_build_object('owner', current_user)) AS allowed;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Policy denies rows owned by someone else
+    When I execute SQL:
+      """
+      SELECT cel_policy('row.owner == principal.user', '{"owner": "somebody_else"}'::jsonb) AS allowed;
+      """
+    Then the SQL result should be "false"
+
+  Scenario: Policy uses custom claims and role memberships
+    When I execute SQL:
+      """
+      SELECT cel_policy('"auditor" in principal.claims.groups || row.tenant == principal.claims.tenant',
+                        '{"tenant": "acme"}'::jsonb,
+                        cel_principal() || '{"claims": {"tenant": "acme", "groups": []}}'::jsonb) AS allowed;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Policy runtime errors deny access
+    When I execute SQL:
+      """
+      SELECT cel_policy('principal.claims.tenant == row.tenant', '{"tenant": "acme"}'::jsonb,
+                        '{"user": "alice", "roles": [], "claims": {}}'::jsonb) AS allowed;
+      """
+    Then the SQL result should be "false"
+
+  Scenario: Invalid policies are reported
+    When I execute SQL:
+      """
+      SELECT cel_policy('row.owner ==', '{}'::jsonb) AS allowed;
+      """
+    Then I should receive an error
+    And the error message should contain "CEL compilation error"
+
+  Scenario: Principal exposes the current user
+    When I execute SQL:
+      """
+      SELECT cel_principal() ->> 'user' = current_user AS is_current_user;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Claims must be a JSON object
+    When I execute SQL:
+      """
+      SELECT cel_set_claims('["admin"]'::jsonb);
+      """
+    Then I should receive an error
@@ -0,0 +1,96 @@
+Feature: CEL Rule Evaluation
+  In order to check one event against many rules efficiently
+  As a rules engine developer
+  I need to evaluate a whole rule set against a document in a single call
+
+  Background:
+    Given pg-cel extension is loaded
+
+  Scenario: Matching rule ids from a rule object
+    When I execute SQL:
+      """
+      SELECT string_agg(rule_id, ',' ORDER BY rule_id) AS matched
+      FROM cel_eval_rules('{"age": 25, "country": "DE"}'::jsonb,
+                          '{"adult": "age >= 18.0", "eu": "country in [\"DE\", \"FR\"]", "minor": "age < 18.0"}'::jsonb);
+      """
+    Then the SQL result should be "adult,eu"
+
+  Scenario: Rule values are returned as JSON
+    When I execute SQL:
+      """
+      SELECT value #>> '{}' AS value
+      FROM cel_eval_rules('{"name": "Alice"}'::jsonb, '[{"id": "greeting", "expression": "\"Hello \" + name"}]'::jsonb);
+      """
+    Then the SQL result should be "Hello Alice"
+
+  Scenario: Rules given as a text array use positional ids
+    When I execute SQL:
+      """
+      SELECT string_agg(rule_id, ',' ORDER BY rule_id) AS matched
+      FROM cel_eval_rules('{"score": 80}'::jsonb, ARRAY['score > 50.0', 'score > 90.0', 'score > 70.0']);
+      """
+    Then the SQL result should be "1,3"
+
+  Scenario: Only true matches a boolean rule and other values are returned as they are
+    When I execute SQL:
+      """
+      SELECT string_agg(rule_id || '=' || value::text, ',' ORDER BY rule_id) AS matched
+      FROM cel_eval_rules('{"n": 0, "s": ""}'::jsonb,
+                          '{"falsy": "n > 1.0", "nothing": "null", "truthy": "n == 0.0", "zero": "n", "empty": "s", "list": "[]"}'::jsonb);
+      """
+    Then the SQL result should be "empty="",list=[],truthy=true,zero=0"
+
+  Scenario: Object rule sets are evaluated in key order
+    When I execute SQL:
+      """
+      SELECT string_agg(r.id, ',') AS ordered
+      FROM json_to_recordset(cel_eval_rules_json('{"x": 1}', '{"r2": "x > 0.0", "r10": "x > 0.0", "r1": "x > 0.0"}')::json) AS r(id text);
+      """
+    Then the SQL result should be "r2,r10,r1"
+
+  Scenario: Failing rules report their error without affecting other rules
+    When I execute SQL:
+      """
+      SELECT count(*) FILTER (WHERE error LIKE 'CEL compilation error%') || '/' || count(*) AS summary
+      FROM cel_eval_rules('{"a": true}'::jsonb, '{"ok": "a", "broken": "missing.field"}'::jsonb);
+      """
+    Then the SQL result should be "1/2"
+
+  Scenario: Invalid rule set is rejected
+    When I execute SQL:
+      """
+      SELECT * FROM cel_eval_rules('{}'::jsonb, '"not a rule set"'::jsonb);
+      """
+    Then I should receive an error
+
+  Scenario: Decision returns the highest-priority matching output
+    When I execute SQL:
+      """
+      SELECT cel_decide('{"rules": [
+          {"id": "regular", "priority": 10, "condition": "total > 100.0", "output": "\"regular\""},
+          {"id": "vip", "priority": 100, "condition": "total > 1000.0", "output": "\"vip\""}
+        ], "default": "\"none\""}'::jsonb, '{"total": 5000}'::jsonb) #>> '{}' AS decision;
+      """
+    Then the SQL result should be "vip"
+
+  Scenario: Decision falls back to the default output
+    When I execute SQL:
+      """
+      SELECT cel_decide('{"rules": [{"priority": 1, "condition": "total > 100.0", "output": "1"}],
+                          "default": "total * 0.0"}'::jsonb, '{"total": 5}'::jsonb)::text AS decision;
+      """
+    Then the SQL result should be "0"
+
+  Scenario: Decision without a match or default is NULL
+    When I execute SQL:
+      """
+      SELECT cel_decide('[{"condition": "false", "output": "1"}]'::jsonb, '{}'::jsonb) IS NULL AS no_decision;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Non-boolean decision condition is an error
+    When I execute SQL:
+      """
+      SELECT cel_decide('[{"condition": "total", "output": "1"}]'::jsonb, '{"total": 5}'::jsonb);
+      """
+    Then I should receive an error
@@ -0,0 +1,55 @@
+Feature: CEL Document Transformation
+  In order to reshape JSON documents inside queries
+  As a database user
+  I need CEL expressions that build new objects and lists returned as jsonb
+
+  Background:
+    Given pg-cel extension is loaded
+
+  Scenario: Transform builds a typed jsonb object
+    When I execute SQL:
+      """
+      SELECT cel_transform('{"user": {"first": "Ada", "last": "Lovelace", "age": 36}}'::jsonb,
+                           '{"name": user.first + " " + user.last, "adult": user.age >= 18.0}')
+             = '{"name": "Ada Lovelace", "adult": true}'::jsonb AS matches;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Transform returns lists
+    When I execute SQL:
+      """
+      SELECT jsonb_typeof(cel_transform('{"items": [1, 2, 3]}'::jsonb, 'items.map(i, i * 2.0)')) || ':' ||
+             cel_transform('{"items": [1, 2, 3]}'::jsonb, 'items.map(i, i * 2.0)')::text AS doubled;
+      """
+    Then the SQL result should be "array:[2, 4, 6]"
+
+  Scenario: Transform results can be queried as jsonb
+    When I execute SQL:
+      """
+      SELECT cel_transform('{"price": 10, "qty": 3}'::jsonb, '{"total": price * qty}') ->> 'total' AS total;
+      """
+    Then the SQL result should be "30"
+
+  Scenario: Transform reports evaluation errors
+    When I execute SQL:
+      """
+      SELECT cel_transform('{"a": 1}'::jsonb, '{"b": missing}');
+      """
+    Then I should receive an error
+
+  Scenario: Projection computes every field of a spec
+    When I execute SQL:
+      """
+      SELECT cel_project('{"first": "Ada", "last": "Lovelace", "price": 10, "qty": 3}'::jsonb,
+                         '{"full_name": "first + \" \" + last", "total": "price * qty", "bulk": "qty > 10.0"}'::jsonb)
+             = '{"full_name": "Ada Lovelace", "total": 30, "bulk": false}'::jsonb AS matches;
+      """
+    Then the SQL result should be "true"
+
+  Scenario: Projection errors name the failing field
+    When I execute SQL:
+      """
+      SELECT cel_project('{"a": 1}'::jsonb, '{"ok": "a", "broken": "b + 1"}'::jsonb);
+      """
+    Then I should receive an error
+    And the error message should contain "broken"
@@ -0,0 +1,98 @@
+Feature: CEL Validation
+  In order to keep invalid data out of my tables
+  As a database developer
+  I need to declare validation rules in CEL and have violations rejected
+
+  Background:
+    Given pg-cel extension is loaded
+
+  Scenario: Trigger accepts a valid row
+    Given I have a table with a CEL validation trigger
+    When I execute SQL:
+      """
+      INSERT INTO validated_products (name, price) VALUES ('Gadget', 25) RETURNING name;
+      """
+    Then the SQL result should be "Gadget"
+
+  Scenario: Trigger rejects a row violating a rule
+    Given I have a table with a CEL validation trigger
+    When I execute SQL:
+      """
+      INSERT INTO validated_products (name, price) VALUES ('Freebie', 0) RETURNING name;
+      """
+    Then I should receive an error
+    And the error message should contain "violates CEL rule: new.price > 0.0"
+
+  Scenario: Trigger rules can compare old and new rows
+    Given I have a table with a CEL validation trigger
+    When I execute SQL:
+      """
+      UPDATE validated_products SET price = 100 WHERE name = 'Widget' RETURNING name;
+      """
+    Then I should receive an error
+    And the error message should contain "old.price"
+
+  Scenario: Trigger rejects rows whose rules fail to evaluate
+    Given I have a table with a CEL validation trigger
+    When I execute SQL:
+      """
+      INSERT INTO validated_products (name, price) VALUES (NULL, 5) RETURNING name;
+      """
+    Then I should receive an error
+
+  Scenario: Validation report lists every violation
+    When I execute SQL:
+      """
+      SELECT string_agg(rule_id || '@' || path, ',' ORDER BY rule_id) AS violations
+      FROM cel_validate('{"user": {"age": 15, "email": "nobody"}}'::jsonb, '[
+        {"id": "adult", "expression": "user.age >= 18.0"},
+        {"id": "email", "expression": "user.email.contains(\"@\")"},
+        {"id": "named", "expression": "has(user.name)", "path": "user.name"},
+        {"id": "positive", "expression": "user.age > 0.0"}
+      ]'::jsonb);
+      """
+    Then the SQL result should be "adult@user.age,email@user.email,named@user.name"
+
+  Scenario: Validation messages can be CEL string expressions
+    When I execute SQL:
+      """
+      SELECT message
+      FROM cel_validate('{"age": 15}'::jsonb,
+                        '[{"id": "adult", "expression": "age >= 18.0", "message_expr": "\"must be 18, got \" + string(age)"}]'::jsonb);
+      """
+    Then the SQL result should be "must be 18, got 15"
+
+  Scenario: Literal validation messages are returned as written
+    When I execute SQL:
+      """
+      SELECT message
+      FROM cel_validate('{"age": 15}'::jsonb,
+                        '[{"expression": "age >= 18.0", "message": "Applicant must be an adult"}]'::jsonb);
+      """
+    Then the SQL result should be "Applicant must be an adult"
+
+  Scenario: Literal messages naming a document field are not evaluated
+    When I execute SQL:
+      """
+      SELECT message
+      FROM cel_validate('{"email": "nobody"}'::jsonb,
+                        '[{"expression": "email.contains(\"@\")", "message": "email"}]'::jsonb);
+      """
+    Then the SQL result should be "email"
+
+  Scenario: Failing message expressions fall back to the literal message
+    When I execute SQL:
+      """
+      SELECT message
+      FROM cel_validate('{"age": 15}'::jsonb,
+                        '[{"expression": "age >= 18.0", "message": "Applicant must be an adult", "message_expr": "missing + \"!\""}]'::jsonb);
+      """
+    Then the SQL result should be "Applicant must be an adult"
+
+  Scenario: Valid documents produce no violations
+    When I execute SQL:
+      """
+      SELECT count(*) AS violations
+      FROM cel_validate('{"age": 40}'::jsonb, '["age >= 18.0", "age < 130.0"]'::jsonb);
+      """
+    Then the SQL result should be "0"
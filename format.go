@@ -0,0 +1,75 @@
+package main
+
+import "C"
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/parser"
+)
+
+// parseWithMacroCalls parses an expression keeping the original macro calls,
+// so that it can be unparsed as written (items.all(i, ...)) rather than as a loop
+func parseWithMacroCalls(exprString string) (*ast.AST, error) {
+	celEnv, err := createCELEnv()
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %v", err)
+	}
+	celEnv, err = celEnv.Extend(cel.EnableMacroCallTracking())
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %v", err)
+	}
+
+	parsed, issues := celEnv.Parse(exprString)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("CEL parse error: %v", issues.Err())
+	}
+	return parsed.NativeRep(), nil
+}
+
+// unparseExpr renders an expression of a parsed AST in canonical form. With a wrap column
+// of zero the result is a single line; otherwise lines are broken before && and || once
+// they reach the column.
+func unparseExpr(parsed *ast.AST, expr ast.Expr, wrapColumn int) (string, error) {
+	if wrapColumn <= 0 {
+		return parser.Unparse(expr, parsed.SourceInfo(), parser.WrapOnColumn(math.MaxInt32))
+	}
+	return parser.Unparse(expr, parsed.SourceInfo(),
+		parser.WrapOnColumn(wrapColumn),
+		parser.WrapOnOperators(operators.LogicalAnd, operators.LogicalOr),
+		parser.WrapAfterColumnLimit(false),
+	)
+}
+
+// formatExpression parses an expression and unparses it in canonical form.
+// Comments and redundant parentheses are dropped and literals are normalized.
+func formatExpression(exprString string, wrapColumn int) (string, error) {
+	parsed, err := parseWithMacroCalls(exprString)
+	if err != nil {
+		return "", err
+	}
+
+	formatted, err := unparseExpr(parsed, parsed.Expr(), wrapColumn)
+	if err != nil {
+		return "", fmt.Errorf("CEL format error: %v", err)
+	}
+	return formatted, nil
+}
+
+//export pg_cel_format
+func pg_cel_format(expressionStr *C.char, wrapColumn C.int, errorOut **C.char) *C.char {
+	// Convert C string to Go string
+	exprString := C.GoString(expressionStr)
+
+	formatted, err := formatExpression(exprString, int(wrapColumn))
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	return C.CString(formatted)
+}
@@ -0,0 +1,219 @@
+package main
+
+import "C"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/ristretto/v2"
+	"github.com/dgraph-io/ristretto/v2/z"
+	"github.com/google/cel-go/cel"
+)
+
+// indexedProgram records which environment and expression a cached program was compiled
+// for, so programs can be invalidated without knowing their type fingerprints, and the
+// cost it was cached with, so a shrinking cache knows how many to evict
+type indexedProgram struct {
+	conflict    uint64
+	environment celEnvironment
+	expression  string
+	cost        int64
+}
+
+// The cache cannot list its keys, so the programs it holds are indexed by key hash.
+// Only the hashes are kept, since keys can be as large as a serialized compiled
+// expression. Entries are removed when the cache evicts or rejects a program; the
+// callbacks run on cache goroutines.
+var (
+	programIndexMu sync.Mutex
+	programIndex   = make(map[uint64]indexedProgram)
+)
+
+// knownEnvironments are the environment names programs can be invalidated by
+var knownEnvironments = []celEnvironment{
+	staticEnvironment, documentEnvironment, triggerEnvironment, policyEnvironment, compiledEnvironment,
+}
+
+// hashedKey encodes a key hash as a program cache key, so indexed programs can be
+// deleted without their keys. Program cache keys start with an environment name, so
+// they never take this form.
+func hashedKey(keyHash uint64, conflict uint64) string {
+	key := make([]byte, 17)
+	binary.LittleEndian.PutUint64(key[1:9], keyHash)
+	binary.LittleEndian.PutUint64(key[9:], conflict)
+	return string(key)
+}
+
+// programKeyToHash hashes program cache keys, decoding the keys made by hashedKey
+func programKeyToHash(key string) (uint64, uint64) {
+	if len(key) == 17 && key[0] == 0 {
+		return binary.LittleEndian.Uint64([]byte(key[1:9])), binary.LittleEndian.Uint64([]byte(key[9:]))
+	}
+	return z.KeyToHash(key)
+}
+
+// cacheProgram stores a compiled program under its cache key and indexes it by
+// environment and expression
+func cacheProgram(cacheKey string, environment celEnvironment, exprString string, prg cel.Program, cost int64) {
+	keyHash, conflict := programKeyToHash(cacheKey)
+
+	programIndexMu.Lock()
+	programIndex[keyHash] = indexedProgram{
+		conflict:    conflict,
+		environment: environment,
+		expression:  exprString,
+		cost:        cost,
+	}
+	programIndexMu.Unlock()
+
+	// Sets are dropped when the cache is contended; the program is then not cached
+	if !programCache.Set(cacheKey, prg, cost) {
+		unindexProgram(keyHash, conflict)
+	}
+	// Wait for cache operation to complete
+	programCache.Wait()
+}
+
+// unindexProgram drops a program from the index
+func unindexProgram(keyHash uint64, conflict uint64) {
+	programIndexMu.Lock()
+	defer programIndexMu.Unlock()
+
+	if indexed, found := programIndex[keyHash]; found && indexed.conflict == conflict {
+		delete(programIndex, keyHash)
+	}
+}
+
+// forgetProgram drops a program the cache evicted or rejected from the index
+func forgetProgram(item *ristretto.Item[cel.Program]) {
+	unindexProgram(item.Key, item.Conflict)
+}
+
+// resetProgramIndex forgets every indexed program, for when the program cache is cleared
+func resetProgramIndex() {
+	programIndexMu.Lock()
+	defer programIndexMu.Unlock()
+
+	programIndex = make(map[uint64]indexedProgram)
+}
+
+// invalidatePrograms removes every cached program matching a predicate, including the
+// variants compiled for other documents and with coverage tracking, and returns how many
+// were removed
+func invalidatePrograms(matches func(indexedProgram) bool) int {
+	programIndexMu.Lock()
+	var invalidated []string
+	for keyHash, indexed := range programIndex {
+		if matches(indexed) {
+			invalidated = append(invalidated, hashedKey(keyHash, indexed.conflict))
+			delete(programIndex, keyHash)
+		}
+	}
+	programIndexMu.Unlock()
+
+	for _, key := range invalidated {
+		programCache.Del(key)
+	}
+	programCache.Wait()
+	return len(invalidated)
+}
+
+// evictPrograms removes the programs whose expressions were served from cache least
+// often until the program cache holds at most maxCost bytes, and returns how many were
+// removed. Ristretto only evicts when entries are added, so a cache that shrinks in a
+// session that stops compiling would otherwise keep its memory.
+func evictPrograms(maxCost int64) int {
+	type candidate struct {
+		keyHash uint64
+		indexed indexedProgram
+		hits    int64
+	}
+
+	evicted := 0
+	for cacheBytes(programCache) > maxCost {
+		programIndexMu.Lock()
+		candidates := make([]candidate, 0, len(programIndex))
+		for keyHash, indexed := range programIndex {
+			candidates = append(candidates, candidate{keyHash: keyHash, indexed: indexed})
+		}
+		programIndexMu.Unlock()
+		if len(candidates) == 0 {
+			break
+		}
+
+		for i := range candidates {
+			candidates[i].hits = expressionCacheHits(candidates[i].indexed.environment, candidates[i].indexed.expression)
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].hits < candidates[j].hits })
+
+		// Entries also carry ristretto's own bookkeeping, so a pass can fall short
+		excess := cacheBytes(programCache) - maxCost
+		for _, c := range candidates {
+			if excess <= 0 {
+				break
+			}
+			unindexProgram(c.keyHash, c.indexed.conflict)
+			programCache.Del(hashedKey(c.keyHash, c.indexed.conflict))
+			excess -= c.indexed.cost
+			evicted++
+		}
+		programCache.Wait()
+	}
+	return evicted
+}
+
+// parseEnvironment validates an environment name
+func parseEnvironment(name string) (celEnvironment, error) {
+	for _, environment := range knownEnvironments {
+		if string(environment) == name {
+			return environment, nil
+		}
+	}
+	names := make([]string, len(knownEnvironments))
+	for i, environment := range knownEnvironments {
+		names[i] = string(environment)
+	}
+	return "", fmt.Errorf("unknown CEL environment %q: expected one of %s", name, strings.Join(names, ", "))
+}
+
+//export pg_cel_cache_invalidate
+func pg_cel_cache_invalidate(expressionStr *C.char) C.int {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	exprString := C.GoString(expressionStr)
+	return C.int(invalidatePrograms(func(indexed indexedProgram) bool {
+		return indexed.expression == exprString
+	}))
+}
+
+//export pg_cel_cache_invalidate_prefix
+func pg_cel_cache_invalidate_prefix(prefixStr *C.char) C.int {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	prefix := C.GoString(prefixStr)
+	return C.int(invalidatePrograms(func(indexed indexedProgram) bool {
+		return strings.HasPrefix(indexed.expression, prefix)
+	}))
+}
+
+//export pg_cel_cache_invalidate_environment
+func pg_cel_cache_invalidate_environment(environmentStr *C.char, errorOut **C.char) C.int {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	environment, err := parseEnvironment(C.GoString(environmentStr))
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return 0
+	}
+
+	return C.int(invalidatePrograms(func(indexed indexedProgram) bool {
+		return indexed.environment == environment
+	}))
+}
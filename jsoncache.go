@@ -0,0 +1,134 @@
+package main
+
+import "C"
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// jsonDoorkeeperSize bounds how many once-seen documents are remembered for admission
+const jsonDoorkeeperSize = 1 << 16
+
+// JSON cache policy, changed by the GUC assign hooks on the backend thread.
+// A document is cached the second time it is parsed and only if it is no larger than
+// jsonCacheMaxEntryBytes; one-off documents are parsed without evicting useful entries.
+var (
+	jsonCacheEnabled       = true
+	jsonCacheMaxEntryBytes = 1024 * 1024
+	// jsonCacheTTL expires cached documents; zero keeps them until evicted
+	jsonCacheTTL time.Duration
+
+	// jsonDoorkeeperMu guards the doorkeeper and the skip counters
+	jsonDoorkeeperMu sync.Mutex
+	jsonDoorkeeper   = make(map[uint64]struct{})
+
+	// Documents parsed without being cached, reported by pg_cel_cache_stats
+	jsonSkippedOversize int64
+	jsonSkippedUnseen   int64
+)
+
+// jsonCacheKey hashes a document together with the namespace it was parsed for, so the
+// cache keeps a fixed-size digest per entry rather than the document text
+func jsonCacheKey(namespace string, jsonString string) string {
+	digest := sha256.New()
+	digest.Write([]byte(namespace))
+	digest.Write([]byte{0})
+	digest.Write([]byte(jsonString))
+	return string(digest.Sum(nil))
+}
+
+// getCachedJSON returns a parsed document from the JSON cache
+func getCachedJSON(key string) (map[string]any, bool) {
+	if !jsonCacheEnabled {
+		return nil, false
+	}
+	env, found := jsonCache.Get(key)
+	if found {
+		countStat(statJSONHits, 1)
+	} else {
+		countStat(statJSONMisses, 1)
+	}
+	return env, found
+}
+
+// admitJSON reports whether a parsed document should be cached: it must fit the per-entry
+// ceiling and have been parsed before
+func admitJSON(key string, size int) bool {
+	if !jsonCacheEnabled {
+		return false
+	}
+
+	jsonDoorkeeperMu.Lock()
+	defer jsonDoorkeeperMu.Unlock()
+
+	if jsonCacheMaxEntryBytes > 0 && size > jsonCacheMaxEntryBytes {
+		jsonSkippedOversize++
+		return false
+	}
+
+	fingerprint := binary.LittleEndian.Uint64([]byte(key))
+	if _, seen := jsonDoorkeeper[fingerprint]; seen {
+		delete(jsonDoorkeeper, fingerprint)
+		return true
+	}
+	// Forget every once-seen document when the doorkeeper is full
+	if len(jsonDoorkeeper) >= jsonDoorkeeperSize {
+		clear(jsonDoorkeeper)
+	}
+	jsonDoorkeeper[fingerprint] = struct{}{}
+	jsonSkippedUnseen++
+	return false
+}
+
+// cacheJSON stores a parsed document if the admission policy accepts it. The cost is
+// the estimated memory of the decoded variables.
+func cacheJSON(key string, jsonString string, env map[string]any, cost int64) {
+	if !admitJSON(key, len(jsonString)) {
+		return
+	}
+
+	jsonCache.SetWithTTL(key, env, cost, jsonCacheTTL)
+	// Wait for cache operation to complete
+	jsonCache.Wait()
+}
+
+// jsonSkipCounts returns how many documents were parsed without being cached because
+// they were over the size ceiling or seen for the first time
+func jsonSkipCounts() (int64, int64) {
+	jsonDoorkeeperMu.Lock()
+	defer jsonDoorkeeperMu.Unlock()
+
+	return jsonSkippedOversize, jsonSkippedUnseen
+}
+
+// resetJSONDoorkeeper forgets once-seen documents and the skip counters
+func resetJSONDoorkeeper() {
+	jsonDoorkeeperMu.Lock()
+	defer jsonDoorkeeperMu.Unlock()
+
+	clear(jsonDoorkeeper)
+	jsonSkippedOversize = 0
+	jsonSkippedUnseen = 0
+}
+
+//export pg_cel_set_json_cache_enabled
+func pg_cel_set_json_cache_enabled(enabled C.int) {
+	jsonCacheEnabled = enabled != 0
+	if !jsonCacheEnabled && jsonCache != nil {
+		jsonCache.Clear()
+		resetJSONDoorkeeper()
+	}
+}
+
+//export pg_cel_set_json_cache_ttl
+func pg_cel_set_json_cache_ttl(seconds C.int) {
+	jsonCacheTTL = time.Duration(seconds) * time.Second
+}
+
+//export pg_cel_set_json_cache_max_entry_kb
+func pg_cel_set_json_cache_max_entry_kb(maxEntryKB C.int) {
+	jsonCacheMaxEntryBytes = int(maxEntryKB) * 1024
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"log"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/dgraph-io/ristretto/v2"
 	"github.com/google/cel-go/cel"
@@ -35,10 +37,14 @@ func pg_init_caches(programCacheMB int, jsonCacheMB int) {
 		MaxCost:     programCacheSize, // maximum cost of cache (configurable)
 		BufferItems: 64,               // number of keys per Get buffer
 		Metrics:     true,             // Enable metrics tracking
+		OnEvict:     forgetProgram,    // Keep the invalidation index in step with the cache
+		OnReject:    forgetProgram,
+		KeyToHash:   programKeyToHash, // Lets the index delete programs by key hash
 	})
 	if err != nil {
 		log.Fatalf("Failed to create program cache: %v", err)
 	}
+	resetProgramIndex()
 
 	// Initialize JSON cache
 	jsonCache, err = ristretto.NewCache(&ristretto.Config[string, map[string]any]{
@@ -52,6 +58,36 @@ func pg_init_caches(programCacheMB int, jsonCacheMB int) {
 	}
 }
 
+// cacheBytes returns the cost of the entries a cache holds
+func cacheBytes[V any](cache *ristretto.Cache[string, V]) int64 {
+	return int64(cache.Metrics.CostAdded() - cache.Metrics.CostEvicted())
+}
+
+//export pg_cel_resize_program_cache
+func pg_cel_resize_program_cache(programCacheMB int) {
+	if programCache == nil {
+		return
+	}
+	// Entries are kept while they fit; on shrink the least used are evicted right away
+	maxCost := int64(programCacheMB) * 1024 * 1024
+	programCache.UpdateMaxCost(maxCost)
+	evictPrograms(maxCost)
+}
+
+//export pg_cel_resize_json_cache
+func pg_cel_resize_json_cache(jsonCacheMB int) {
+	if jsonCache == nil {
+		return
+	}
+	// Cached documents cannot be listed, so a cache that no longer fits is emptied;
+	// documents are cheap to parse again and the doorkeeper readmits repeated ones
+	maxCost := int64(jsonCacheMB) * 1024 * 1024
+	jsonCache.UpdateMaxCost(maxCost)
+	if cacheBytes(jsonCache) > maxCost {
+		jsonCache.Clear()
+	}
+}
+
 func init() {
 	// Default initialization removed to avoid duplicate cache initialization
 	// In PostgreSQL, pg_init_caches will be called with configured GUC values
@@ -65,10 +101,9 @@ func ensureCachesInitialized() {
 	}
 }
 
-// Create a CEL environment with common extensions
-func createCELEnv() (*cel.Env, error) {
-	return cel.NewEnv(
-		// Enable useful extensions
+// celExtensionOptions returns the CEL extensions enabled in every pg-cel environment
+func celExtensionOptions() []cel.EnvOption {
+	return []cel.EnvOption{
 		ext.Strings(),
 		ext.Math(),
 		ext.Lists(),
@@ -76,9 +111,15 @@ func createCELEnv() (*cel.Env, error) {
 		ext.Protos(),
 		ext.Encoders(),
 		ext.Sets(),
+	}
+}
+
+// Create a CEL environment with common extensions
+func createCELEnv() (*cel.Env, error) {
+	return cel.NewEnv(append(celExtensionOptions(),
 		// Enable optional types extension for some advanced functions
 		cel.OptionalTypes(),
-	)
+	)...)
 }
 
 // getCELType converts Go values to appropriate CEL types
@@ -105,26 +146,16 @@ func getCELType(value any) *cel.Type {
 
 // createDynamicCELEnv creates a CEL environment with JSON variables declared
 func createDynamicCELEnv(jsonData map[string]any) (*cel.Env, error) {
-	var envOpts []cel.EnvOption
+	return createDeclaredCELEnv(documentDeclarations(jsonData))
+}
 
-	// Add JSON variables as CEL variables
+// documentDeclarations returns the CEL type each variable of a parsed document is declared with
+func documentDeclarations(jsonData map[string]any) map[string]*cel.Type {
+	declarations := make(map[string]*cel.Type, len(jsonData))
 	for key, value := range jsonData {
-		celType := getCELType(value)
-		envOpts = append(envOpts, cel.Variable(key, celType))
+		declarations[key] = variableType(key, value)
 	}
-
-	// Add extensions
-	envOpts = append(envOpts,
-		ext.Strings(),
-		ext.Math(),
-		ext.Lists(),
-		ext.Bindings(),
-		ext.Protos(),
-		ext.Encoders(),
-		ext.Sets(),
-	)
-
-	return cel.NewEnv(envOpts...)
+	return declarations
 }
 
 // addReferenceVars handles dotted notation like "user.name" by adding reference variables
@@ -149,25 +180,57 @@ func addReferenceVars(envOpts []cel.EnvOption, jsonData map[string]any) []cel.En
 	return envOpts
 }
 
-// createCacheKey generates a cache key that includes both expression and JSON structure
-func createCacheKey(expression string, jsonData map[string]any) string {
-	// Simple approach: include the JSON keys in the cache key
-	// This ensures different JSON structures get different compiled programs
-	if len(jsonData) == 0 {
-		return expression
+// typeFingerprint describes the variable declarations documentDeclarations derives from a document:
+// each top-level key with the CEL type of its value, in key order. Nested values are declared
+// as dyn inside list(dyn) and map(string, dyn), so their shapes do not affect compilation and
+// are left out; documents with the same fingerprint can share a compiled program.
+func typeFingerprint(declarations map[string]*cel.Type) string {
+	keys := make([]string, 0, len(declarations))
+	for key := range declarations {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	// Create a deterministic key based on the JSON structure
-	var keys []string
-	for key := range jsonData {
-		keys = append(keys, key)
+	var fingerprint strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			fingerprint.WriteByte(',')
+		}
+		// Quote names so that keys containing separators cannot collide
+		fmt.Fprintf(&fingerprint, "%q:%s", key, declarations[key])
 	}
+	return fingerprint.String()
+}
 
-	// Sort keys for deterministic cache keys
-	// Note: In production, you'd want to use a proper sorting algorithm
-	sort.Strings(keys)
-	keyStr := fmt.Sprintf("%v", keys)
-	return fmt.Sprintf("%s|%s", expression, keyStr)
+// celEnvironment names the environment a program is compiled in. Program cache keys start
+// with the environment name, so a program is only served to the entry point that built it.
+type celEnvironment string
+
+const (
+	// staticEnvironment has no variables and enables optional types (cel_eval)
+	staticEnvironment celEnvironment = "static"
+	// documentEnvironment declares variables from a JSON document (cel_eval_json and friends)
+	documentEnvironment celEnvironment = "document"
+	// triggerEnvironment binds new, old and op for trigger validation rules
+	triggerEnvironment celEnvironment = "trigger"
+	// policyEnvironment binds row and principal for row-level security policies
+	policyEnvironment celEnvironment = "policy"
+	// compiledEnvironment plans stored checked expressions (cel_eval_compiled)
+	compiledEnvironment celEnvironment = "compiled"
+)
+
+// cacheKey returns a cache key in the environment's namespace
+func (environment celEnvironment) cacheKey(key string) string {
+	return string(environment) + "|" + key
+}
+
+// createCacheKey generates a cache key from the expression and the document's type fingerprint,
+// so a program is only reused for documents that declare the same variables with the same types
+func createCacheKey(expression string, declarations map[string]*cel.Type) string {
+	if len(declarations) == 0 {
+		return expression
+	}
+	return fmt.Sprintf("%s|%s", expression, typeFingerprint(declarations))
 }
 
 //export pg_cel_eval
@@ -180,7 +243,9 @@ func pg_cel_eval(expressionStr *C.char, dataStr *C.char) *C.char {
 	dataString := C.GoString(dataStr)
 
 	// Try to get compiled program from cache
-	if cachedProgram, found := programCache.Get(exprString); found {
+	cacheKey := programCacheKey(staticEnvironment, exprString)
+	if cachedProgram, found := programCache.Get(cacheKey); found {
+		recordCacheHit(cachedProgram)
 		compiledProgram := cachedProgram
 
 		// Parse data as simple environment
@@ -207,6 +272,7 @@ func pg_cel_eval(expressionStr *C.char, dataStr *C.char) *C.char {
 	}
 
 	// Create CEL environment
+	started := time.Now()
 	celEnv, err := createCELEnv()
 	if err != nil {
 		errorMsg := fmt.Sprintf("CEL environment creation error: %v", err)
@@ -220,16 +286,15 @@ func pg_cel_eval(expressionStr *C.char, dataStr *C.char) *C.char {
 		return C.CString(errorMsg)
 	}
 
-	prg, err := celEnv.Program(ast)
+	prg, err := newProgram(celEnv, ast, exprString)
 	if err != nil {
 		errorMsg := fmt.Sprintf("CEL program creation error: %v", err)
 		return C.CString(errorMsg)
 	}
+	prg = profileProgram(staticEnvironment, exprString, prg, started)
 
 	// Cache the compiled program
-	programCache.Set(exprString, prg, 1)
-	// Wait for cache operation to complete
-	programCache.Wait()
+	cacheProgram(cacheKey, staticEnvironment, exprString, prg, programCost(ast))
 
 	// Parse data as simple environment
 	var env map[string]any
@@ -254,6 +319,71 @@ func pg_cel_eval(expressionStr *C.char, dataStr *C.char) *C.char {
 	return C.CString(resultStr)
 }
 
+// parseJSONData parses a JSON document into a variable map, using the JSON cache
+func parseJSONData(jsonString string) (map[string]any, error) {
+	if jsonString == "" || jsonString == "{}" {
+		return map[string]any{}, nil
+	}
+
+	// Try to get parsed JSON from cache
+	cacheKey := jsonCacheKey("", jsonString)
+	if cachedEnv, found := getCachedJSON(cacheKey); found {
+		return cachedEnv, nil
+	}
+
+	// Parse JSON (cache miss)
+	env := make(map[string]any)
+	if err := json.Unmarshal([]byte(jsonString), &env); err != nil {
+		return nil, fmt.Errorf("JSON parsing error: %v", err)
+	}
+
+	cacheJSON(cacheKey, jsonString, env, jsonValueCost(env))
+
+	return env, nil
+}
+
+// getJSONProgram returns a compiled program for an expression over the given JSON variables
+func getJSONProgram(exprString string, env map[string]any) (cel.Program, error) {
+	return getDocumentProgram(exprString, documentDeclarations(env))
+}
+
+// getDocumentProgram returns a compiled program for an expression over document variables
+// declared with the given types
+func getDocumentProgram(exprString string, declarations map[string]*cel.Type) (cel.Program, error) {
+	// Create cache key that includes JSON structure
+	cacheKey := programCacheKey(documentEnvironment, createCacheKey(exprString, declarations))
+
+	// Try to get compiled program from cache
+	if cachedProgram, found := programCache.Get(cacheKey); found {
+		recordCacheHit(cachedProgram)
+		return cachedProgram, nil
+	}
+
+	// Create dynamic CEL environment with JSON variables
+	started := time.Now()
+	celEnv, err := createDeclaredCELEnv(declarations)
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %v", err)
+	}
+
+	// Compile the expression (cache miss)
+	ast, issues := celEnv.Compile(exprString)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("CEL compilation error: %v", issues.Err())
+	}
+
+	prg, err := newProgram(celEnv, ast, exprString)
+	if err != nil {
+		return nil, fmt.Errorf("CEL program creation error: %v", err)
+	}
+	prg = profileProgram(documentEnvironment, exprString, prg, started)
+
+	// Cache the compiled program with the composite key
+	cacheProgram(cacheKey, documentEnvironment, exprString, prg, programCost(ast))
+
+	return prg, nil
+}
+
 //export pg_cel_eval_json
 func pg_cel_eval_json(expressionStr *C.char, jsonData *C.char) *C.char {
 	// Ensure caches are initialized
@@ -264,65 +394,14 @@ func pg_cel_eval_json(expressionStr *C.char, jsonData *C.char) *C.char {
 	jsonString := C.GoString(jsonData)
 
 	// Parse JSON data first to determine variable structure
-	var env map[string]any
-	if jsonString != "" && jsonString != "{}" {
-		// Try to get parsed JSON from cache
-		if cachedEnv, found := jsonCache.Get(jsonString); found {
-			env = cachedEnv
-		} else {
-			// Parse JSON (cache miss)
-			env = make(map[string]any)
-			err := json.Unmarshal([]byte(jsonString), &env)
-			if err != nil {
-				errorMsg := fmt.Sprintf("JSON parsing error: %v", err)
-				return C.CString(errorMsg)
-			}
-			// Cache the parsed JSON with cost based on approximate size
-			cost := int64(len(jsonString) / 100) // Rough cost estimation
-			if cost < 1 {
-				cost = 1
-			}
-			jsonCache.Set(jsonString, env, cost)
-			// Wait for cache operation to complete
-			jsonCache.Wait()
-		}
-	} else {
-		env = map[string]any{}
+	env, err := parseDocument(jsonString)
+	if err != nil {
+		return C.CString(err.Error())
 	}
 
-	// Create cache key that includes JSON structure
-	cacheKey := createCacheKey(exprString, env)
-
-	// Try to get compiled program from cache
-	var prg cel.Program
-	if cachedProgram, found := programCache.Get(cacheKey); found {
-		prg = cachedProgram
-	} else {
-		// Create dynamic CEL environment with JSON variables
-		celEnv, err := createDynamicCELEnv(env)
-		if err != nil {
-			errorMsg := fmt.Sprintf("CEL environment creation error: %v", err)
-			return C.CString(errorMsg)
-		}
-
-		// Compile the expression (cache miss)
-		ast, issues := celEnv.Compile(exprString)
-		if issues != nil && issues.Err() != nil {
-			errorMsg := fmt.Sprintf("CEL compilation error: %v", issues.Err())
-			return C.CString(errorMsg)
-		}
-
-		var err2 error
-		prg, err2 = celEnv.Program(ast)
-		if err2 != nil {
-			errorMsg := fmt.Sprintf("CEL program creation error: %v", err2)
-			return C.CString(errorMsg)
-		}
-
-		// Cache the compiled program with the composite key
-		programCache.Set(cacheKey, prg, 1)
-		// Wait for cache operation to complete
-		programCache.Wait()
+	prg, err := getJSONProgram(exprString, env)
+	if err != nil {
+		return C.CString(err.Error())
 	}
 
 	// Execute the expression with the parsed JSON environment
@@ -386,6 +465,7 @@ func pg_cel_cache_stats() *C.char {
 			stats["json_gets_kept"] = jsonMetrics.GetsKept()
 			stats["json_gets_dropped"] = jsonMetrics.GetsDropped()
 			stats["json_entries"] = jsonMetrics.KeysAdded() // Add missing entries count
+			stats["json_skipped_oversize"], stats["json_skipped_unseen"] = jsonSkipCounts()
 		}
 	}
 
@@ -403,9 +483,12 @@ func pg_cel_cache_clear() *C.char {
 	if programCache != nil {
 		programCache.Clear()
 	}
+	resetProgramIndex()
 	if jsonCache != nil {
 		jsonCache.Clear()
 	}
+	resetJSONDoorkeeper()
+	resetProfiles()
 	return C.CString("Cache cleared successfully")
 }
 
@@ -0,0 +1,613 @@
+-- pg_cel--1.5.0--1.6.0.sql
+-- Upgrade script from version 1.5.0 to 1.6.0
+-- This version includes:
+-- - Multi-rule evaluation against a single parsed document (cel_eval_rules)
+-- - Priority-ordered first-match decision rules (cel_decide)
+-- - CEL-driven aggregates (cel_agg_fold, cel_count_if)
+-- - Generic CEL validation trigger (cel_validate_trigger)
+-- - Validation reports listing every violated rule (cel_validate)
+-- - Row-level security policies written in CEL (cel_policy, cel_principal, cel_set_claims)
+-- - jsonb transformations built by CEL expressions (cel_transform)
+-- - Computed projections from a field-to-expression spec (cel_project)
+-- - Expression introspection: AST, references and cost estimates (cel_explain)
+-- - Evaluation traces with the value of every sub-expression (cel_eval_trace)
+-- - Sub-expression coverage tracking (pg_cel.track_coverage, cel_coverage)
+-- - Canonical expression formatting (cel_format)
+-- - Precompiled checked expressions stored as bytea (cel_compile, cel_eval_compiled)
+-- - celexpr data type validated and normalized on input
+-- - Tabular cache statistics and hot-expression profiles (cel_cache_statistics, cel_hot_expressions)
+-- - Program cache pre-warming (cel_cache_warm, pg_cel.warm_query)
+-- - Targeted program cache invalidation (cel_cache_invalidate) and JSON cache TTLs
+-- - Cluster-wide statistics in shared memory (pg_stat_cel, pg_stat_cel_reset)
+-- - Functions evaluating documents are STABLE: pg_cel.document_variable changes how documents are bound
+--
+-- Breaking change: servers with pg_cel in shared_preload_libraries no longer start once the
+-- 1.6.0 library is installed, since the Go runtime pg_cel embeds must not run in the
+-- postmaster. Remove it from shared_preload_libraries before upgrading, and preload
+-- pg_cel_stats instead to collect the pg_stat_cel counters.
+
+-- complain if script is sourced in psql, rather than via ALTER EXTENSION
+\echo Use "ALTER EXTENSION pg_cel UPDATE TO '1.6.0'" to load this file. \quit
+
+-- How documents are bound to variables depends on pg_cel.document_variable, so functions
+-- evaluating documents must not be constant-folded or used in expression indexes
+ALTER FUNCTION cel_eval_json(text, text) STABLE;
+ALTER FUNCTION cel_eval_bool(text, text) STABLE;
+ALTER FUNCTION cel_eval_bool(text, jsonb) STABLE;
+ALTER FUNCTION cel_eval_bool(text, json) STABLE;
+ALTER FUNCTION cel_eval_numeric(text, text) STABLE;
+ALTER FUNCTION cel_eval_numeric(text, jsonb) STABLE;
+ALTER FUNCTION cel_eval_numeric(text, json) STABLE;
+ALTER FUNCTION cel_eval_string(text, text) STABLE;
+ALTER FUNCTION cel_eval_string(text, jsonb) STABLE;
+ALTER FUNCTION cel_eval_string(text, json) STABLE;
+ALTER FUNCTION cel_eval_int(text, text) STABLE;
+ALTER FUNCTION cel_eval_int(text, jsonb) STABLE;
+ALTER FUNCTION cel_eval_int(text, json) STABLE;
+ALTER FUNCTION cel_eval_double(text, text) STABLE;
+ALTER FUNCTION cel_eval_double(text, jsonb) STABLE;
+ALTER FUNCTION cel_eval_double(text, json) STABLE;
+ALTER FUNCTION cel_eval(text, jsonb) STABLE;
+ALTER FUNCTION cel_eval(text, json) STABLE;
+
+-- Function to evaluate a set of CEL rules against one JSON document
+-- Returns a JSON array of {"id", "value"} for matching rules and {"id", "error"} for failing ones;
+-- only true matches a boolean rule, other non-null values are returned as they are
+CREATE OR REPLACE FUNCTION cel_eval_rules_json(json_data text, rules text)
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_eval_rules_pg'
+LANGUAGE C STRICT STABLE;
+
+-- Rule set as a JSON object ({"id": "expression"}, evaluated in key order) or array of expressions / {id, expression} objects
+CREATE OR REPLACE FUNCTION cel_eval_rules(json_data jsonb, rules jsonb)
+RETURNS TABLE(rule_id text, value jsonb, error text)
+AS $$
+    SELECT r.id, r.value, r.error
+    FROM jsonb_to_recordset(public.cel_eval_rules_json(json_data::text, rules::text)::jsonb)
+         AS r(id text, value jsonb, error text);
+$$ LANGUAGE sql STRICT STABLE;
+
+-- Overloaded version for an array of expressions (rule ids are 1-based positions)
+CREATE OR REPLACE FUNCTION cel_eval_rules(json_data jsonb, rules text[])
+RETURNS TABLE(rule_id text, value jsonb, error text)
+AS $$
+    SELECT r.rule_id, r.value, r.error
+    FROM public.cel_eval_rules(json_data, to_jsonb(rules)) AS r;
+$$ LANGUAGE sql STRICT STABLE;
+
+-- Function to return the output of the highest-priority matching decision rule
+-- Rule set: {"rules": [{"id", "priority", "condition", "output"}], "default": "expression"}
+CREATE OR REPLACE FUNCTION cel_decide_json(ruleset text, json_data text)
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_decide_pg'
+LANGUAGE C STRICT STABLE;
+
+CREATE OR REPLACE FUNCTION cel_decide(ruleset jsonb, json_data jsonb)
+RETURNS jsonb
+AS $$
+    SELECT public.cel_decide_json(ruleset::text, json_data::text)::jsonb;
+$$ LANGUAGE sql STRICT STABLE;
+
+-- Aggregate folding documents into a CEL accumulator
+-- init_expr is evaluated once per group; step_expr sees the document fields plus `acc`
+CREATE OR REPLACE FUNCTION cel_agg_fold_transfn(internal, text, text, jsonb)
+RETURNS internal
+AS 'MODULE_PATHNAME', 'cel_agg_fold_transfn_pg'
+LANGUAGE C STABLE;
+
+CREATE OR REPLACE FUNCTION cel_agg_fold_finalfn(internal)
+RETURNS jsonb
+AS 'MODULE_PATHNAME', 'cel_agg_fold_finalfn_pg'
+LANGUAGE C IMMUTABLE;
+
+CREATE OR REPLACE AGGREGATE cel_agg_fold(init_expr text, step_expr text, doc jsonb) (
+    SFUNC = cel_agg_fold_transfn,
+    STYPE = internal,
+    FINALFUNC = cel_agg_fold_finalfn
+);
+
+-- Aggregate counting the documents for which a CEL expression is true
+CREATE OR REPLACE FUNCTION cel_count_if_transfn(internal, text, jsonb)
+RETURNS internal
+AS 'MODULE_PATHNAME', 'cel_count_if_transfn_pg'
+LANGUAGE C STABLE;
+
+CREATE OR REPLACE FUNCTION cel_count_if_finalfn(internal)
+RETURNS bigint
+AS 'MODULE_PATHNAME', 'cel_count_if_finalfn_pg'
+LANGUAGE C IMMUTABLE;
+
+CREATE OR REPLACE AGGREGATE cel_count_if(expression text, doc jsonb) (
+    SFUNC = cel_count_if_transfn,
+    STYPE = internal,
+    FINALFUNC = cel_count_if_finalfn
+);
+
+-- Function to check trigger row data ({"new", "old", "op"}) against validation rules
+-- Returns a JSON array of {"id", "expression", "reason"} for every rule that is not true
+CREATE OR REPLACE FUNCTION cel_validate_row_json(row_data text, rules text)
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_validate_row_pg'
+LANGUAGE C STRICT IMMUTABLE;
+
+-- Generic validation trigger: every TG_ARGV entry is a CEL rule over `new`, `old` and `op`
+-- Usage: CREATE TRIGGER t BEFORE INSERT OR UPDATE ON tbl
+--        FOR EACH ROW EXECUTE FUNCTION cel_validate_trigger('new.price > 0.0', 'new.name != ""');
+CREATE OR REPLACE FUNCTION cel_validate_trigger()
+RETURNS trigger
+AS $$
+DECLARE
+    row_data jsonb;
+    violations jsonb;
+BEGIN
+    IF TG_LEVEL <> 'ROW' THEN
+        RAISE EXCEPTION 'cel_validate_trigger must be fired FOR EACH ROW';
+    END IF;
+    IF TG_NARGS = 0 THEN
+        RAISE EXCEPTION 'cel_validate_trigger requires at least one CEL expression argument';
+    END IF;
+
+    row_data := jsonb_build_object(
+        'new', CASE WHEN TG_OP IN ('INSERT', 'UPDATE') THEN to_jsonb(NEW) END,
+        'old', CASE WHEN TG_OP IN ('UPDATE', 'DELETE') THEN to_jsonb(OLD) END,
+        'op', TG_OP);
+
+    violations := public.cel_validate_row_json(row_data::text, to_jsonb(TG_ARGV)::text)::jsonb;
+
+    IF jsonb_array_length(violations) > 0 THEN
+        RAISE EXCEPTION 'row in %.% violates CEL rule: %',
+                        TG_TABLE_SCHEMA, TG_TABLE_NAME, violations->0->>'expression'
+            USING ERRCODE = 'check_violation',
+                  DETAIL = (SELECT string_agg(format('%s: %s', v->>'expression', v->>'reason'), E'\n')
+                            FROM jsonb_array_elements(violations) AS v);
+    END IF;
+
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Function to check a JSON document against validation rules, reporting every violation
+-- Rules: [{"id", "expression", "message", "message_expr", "path"}]; message is literal text
+-- and message_expr a CEL string expression that takes its place
+CREATE OR REPLACE FUNCTION cel_validate_json(json_data text, rules text)
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_validate_pg'
+LANGUAGE C STRICT STABLE;
+
+CREATE OR REPLACE FUNCTION cel_validate(document jsonb, rules jsonb)
+RETURNS TABLE(rule_id text, message text, path text, reason text)
+AS $$
+    SELECT v.id, v.message, v.path, v.reason
+    FROM jsonb_to_recordset(public.cel_validate_json(document::text, rules::text)::jsonb)
+         AS v(id text, message text, path text, reason text);
+$$ LANGUAGE sql STRICT STABLE;
+
+-- Session principal for CEL row-level security policies:
+-- current user, session user, role memberships and custom claims from pg_cel.claims
+CREATE OR REPLACE FUNCTION cel_principal()
+RETURNS jsonb
+AS $$
+    SELECT jsonb_build_object(
+        'user', current_user::text,
+        'session_user', session_user::text,
+        'roles', COALESCE((SELECT jsonb_agg(r.rolname::text ORDER BY r.rolname)
+                           FROM pg_catalog.pg_roles r
+                           WHERE pg_catalog.pg_has_role(current_user, r.oid, 'MEMBER')), '[]'::jsonb),
+        'claims', COALESCE(NULLIF(current_setting('pg_cel.claims', true), '')::jsonb, '{}'::jsonb));
+$$ LANGUAGE sql STABLE;
+
+-- Function to set the custom claims seen by CEL policies for this session (or transaction)
+CREATE OR REPLACE FUNCTION cel_set_claims(claims jsonb, is_local boolean DEFAULT false)
+RETURNS jsonb
+AS $$
+BEGIN
+    IF jsonb_typeof(claims) <> 'object' THEN
+        RAISE EXCEPTION 'CEL policy claims must be a JSON object, got %', jsonb_typeof(claims);
+    END IF;
+    PERFORM set_config('pg_cel.claims', claims::text, is_local);
+    RETURN claims;
+END;
+$$ LANGUAGE plpgsql STRICT VOLATILE;
+
+-- Function to evaluate a CEL row-level security policy over `row` and `principal`
+-- Compilation errors are raised; runtime errors deny access
+CREATE OR REPLACE FUNCTION cel_policy_json(expression text, row_data text, principal text)
+RETURNS boolean
+AS 'MODULE_PATHNAME', 'cel_policy_pg'
+LANGUAGE C STRICT STABLE;
+
+-- Pass (SELECT cel_principal()) as principal so it is computed once per query
+CREATE OR REPLACE FUNCTION cel_policy(expression text, row_data jsonb, principal jsonb)
+RETURNS boolean
+AS $$
+    SELECT public.cel_policy_json(expression, row_data::text, principal::text);
+$$ LANGUAGE sql STRICT STABLE;
+
+CREATE OR REPLACE FUNCTION cel_policy(expression text, row_data jsonb)
+RETURNS boolean
+AS $$
+    SELECT public.cel_policy(expression, row_data, public.cel_principal());
+$$ LANGUAGE sql STRICT STABLE;
+
+-- Function to build a new JSON value from a document with a CEL expression
+-- Maps, lists and scalars are returned as JSON text with their CEL types preserved
+CREATE OR REPLACE FUNCTION cel_transform_json(json_data text, expression text)
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_transform_pg'
+LANGUAGE C STRICT STABLE;
+
+CREATE OR REPLACE FUNCTION cel_transform(json_data jsonb, expression text)
+RETURNS jsonb
+AS $$
+    SELECT public.cel_transform_json(json_data::text, expression)::jsonb;
+$$ LANGUAGE sql STRICT STABLE;
+
+-- Overloaded version for json input
+CREATE OR REPLACE FUNCTION cel_transform(json_data json, expression text)
+RETURNS jsonb
+AS $$
+    SELECT public.cel_transform_json(json_data::text, expression)::jsonb;
+$$ LANGUAGE sql STRICT STABLE;
+
+-- Function to compute a jsonb object from a {"field": "expression"} spec over one parsed document
+CREATE OR REPLACE FUNCTION cel_project_json(json_data text, spec text)
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_project_pg'
+LANGUAGE C STRICT STABLE;
+
+CREATE OR REPLACE FUNCTION cel_project(document jsonb, spec jsonb)
+RETURNS jsonb
+AS $$
+    SELECT public.cel_project_json(document::text, spec::text)::jsonb;
+$$ LANGUAGE sql STRICT STABLE;
+
+-- Function to describe the checked AST, references and estimated cost of an expression
+CREATE OR REPLACE FUNCTION cel_explain_json(expression text, declarations text)
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_explain_pg'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION cel_explain(expression text, declarations jsonb DEFAULT '{}')
+RETURNS jsonb
+AS $$
+    SELECT public.cel_explain_json(expression, declarations::text)::jsonb;
+$$ LANGUAGE sql STRICT IMMUTABLE;
+
+-- Function to evaluate an expression and report the value of every sub-expression
+CREATE OR REPLACE FUNCTION cel_eval_trace_json(expression text, json_data text)
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_eval_trace_pg'
+LANGUAGE C STRICT STABLE;
+
+-- Steps are in source order; depth is the nesting level below the whole expression (depth 0)
+CREATE OR REPLACE FUNCTION cel_eval_trace(expression text, json_data jsonb)
+RETURNS TABLE(step integer, depth integer, sub_expression text, value jsonb, error text)
+AS $$
+    SELECT t.ordinality::integer, (t.step ->> 'depth')::integer, t.step ->> 'expression', t.step -> 'value', t.step ->> 'error'
+    FROM jsonb_array_elements(public.cel_eval_trace_json(expression, json_data::text)::jsonb)
+         WITH ORDINALITY AS t(step, ordinality);
+$$ LANGUAGE sql STRICT STABLE;
+
+-- Function to dump the sub-expression coverage recorded while pg_cel.track_coverage is on
+CREATE OR REPLACE FUNCTION cel_coverage_json()
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_coverage_pg'
+LANGUAGE C STRICT VOLATILE;
+
+-- One row per sub-expression of every covered expression; hits = 0 marks code that never ran
+CREATE OR REPLACE FUNCTION cel_coverage()
+RETURNS TABLE(expression text, evaluations bigint, step integer, depth integer, sub_expression text,
+              hits bigint, true_hits bigint, false_hits bigint, error_hits bigint)
+AS $$
+    SELECT e.expression, e.evaluations, n.ordinality::integer, (n.node ->> 'depth')::integer,
+           n.node ->> 'expression', (n.node ->> 'hits')::bigint, (n.node ->> 'true_hits')::bigint,
+           (n.node ->> 'false_hits')::bigint, (n.node ->> 'error_hits')::bigint
+    FROM jsonb_to_recordset(public.cel_coverage_json()::jsonb)
+         AS e(expression text, evaluations bigint, nodes jsonb),
+         LATERAL jsonb_array_elements(e.nodes) WITH ORDINALITY AS n(node, ordinality);
+$$ LANGUAGE sql STRICT VOLATILE;
+
+-- Function to discard the recorded coverage
+CREATE OR REPLACE FUNCTION cel_coverage_reset()
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_coverage_reset_pg'
+LANGUAGE C STRICT VOLATILE;
+
+-- Function to parse and unparse an expression into canonical form
+-- wrap_column = 0 gives a single line; otherwise lines break before && and || at that column
+CREATE OR REPLACE FUNCTION cel_format(expression text, wrap_column integer DEFAULT 0)
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_format_pg'
+LANGUAGE C STRICT IMMUTABLE;
+
+-- Function to type-check an expression once and store the serialized checked expression
+CREATE OR REPLACE FUNCTION cel_compile_json(expression text, declarations text)
+RETURNS bytea
+AS 'MODULE_PATHNAME', 'cel_compile_pg'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION cel_compile(expression text, declarations jsonb DEFAULT '{}')
+RETURNS bytea
+AS $$
+    SELECT public.cel_compile_json(expression, declarations::text);
+$$ LANGUAGE sql STRICT IMMUTABLE;
+
+-- Function to evaluate a checked expression produced by cel_compile without re-parsing or re-checking it;
+-- its structure is validated once per backend before it is planned and cached
+CREATE OR REPLACE FUNCTION cel_eval_compiled_json(compiled bytea, json_data text)
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_eval_compiled_pg'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION cel_eval_compiled(compiled bytea, json_data jsonb DEFAULT '{}')
+RETURNS jsonb
+AS $$
+    SELECT public.cel_eval_compiled_json(compiled, json_data::text)::jsonb;
+$$ LANGUAGE sql STRICT IMMUTABLE;
+
+-- CEL expression type: input is parsed (invalid CEL is rejected) and stored in canonical form
+CREATE TYPE celexpr;
+
+CREATE OR REPLACE FUNCTION celexpr_in(cstring)
+RETURNS celexpr
+AS 'MODULE_PATHNAME', 'celexpr_in'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION celexpr_out(celexpr)
+RETURNS cstring
+AS 'MODULE_PATHNAME', 'celexpr_out'
+LANGUAGE C STRICT IMMUTABLE;
+
+-- Binary I/O sends the canonical text; received values are validated like text input
+CREATE OR REPLACE FUNCTION celexpr_recv(internal)
+RETURNS celexpr
+AS 'MODULE_PATHNAME', 'celexpr_recv'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION celexpr_send(celexpr)
+RETURNS bytea
+AS 'MODULE_PATHNAME', 'celexpr_send'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE TYPE celexpr (
+    INPUT = celexpr_in,
+    OUTPUT = celexpr_out,
+    RECEIVE = celexpr_recv,
+    SEND = celexpr_send,
+    INTERNALLENGTH = VARIABLE,
+    STORAGE = extended,
+    CATEGORY = 'S'
+);
+
+CREATE OR REPLACE FUNCTION celexpr(text)
+RETURNS celexpr
+AS 'MODULE_PATHNAME', 'celexpr_from_text'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION celexpr_eq(celexpr, celexpr)
+RETURNS boolean
+AS 'MODULE_PATHNAME', 'celexpr_eq'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION celexpr_ne(celexpr, celexpr)
+RETURNS boolean
+AS 'MODULE_PATHNAME', 'celexpr_ne'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OPERATOR = (
+    LEFTARG = celexpr,
+    RIGHTARG = celexpr,
+    FUNCTION = celexpr_eq,
+    COMMUTATOR = =,
+    NEGATOR = <>,
+    RESTRICT = eqsel,
+    JOIN = eqjoinsel,
+    HASHES,
+    MERGES
+);
+
+CREATE OPERATOR <> (
+    LEFTARG = celexpr,
+    RIGHTARG = celexpr,
+    FUNCTION = celexpr_ne,
+    COMMUTATOR = <>,
+    NEGATOR = =,
+    RESTRICT = neqsel,
+    JOIN = neqjoinsel
+);
+
+-- Canonical forms compare byte-wise, independent of the database collation
+CREATE OR REPLACE FUNCTION celexpr_lt(celexpr, celexpr)
+RETURNS boolean
+AS 'MODULE_PATHNAME', 'celexpr_lt'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION celexpr_le(celexpr, celexpr)
+RETURNS boolean
+AS 'MODULE_PATHNAME', 'celexpr_le'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION celexpr_gt(celexpr, celexpr)
+RETURNS boolean
+AS 'MODULE_PATHNAME', 'celexpr_gt'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION celexpr_ge(celexpr, celexpr)
+RETURNS boolean
+AS 'MODULE_PATHNAME', 'celexpr_ge'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION celexpr_cmp(celexpr, celexpr)
+RETURNS integer
+AS 'MODULE_PATHNAME', 'celexpr_cmp'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION celexpr_hash(celexpr)
+RETURNS integer
+AS 'MODULE_PATHNAME', 'celexpr_hash'
+LANGUAGE C STRICT IMMUTABLE;
+
+CREATE OPERATOR < (
+    LEFTARG = celexpr,
+    RIGHTARG = celexpr,
+    FUNCTION = celexpr_lt,
+    COMMUTATOR = >,
+    NEGATOR = >=,
+    RESTRICT = scalarltsel,
+    JOIN = scalarltjoinsel
+);
+
+CREATE OPERATOR <= (
+    LEFTARG = celexpr,
+    RIGHTARG = celexpr,
+    FUNCTION = celexpr_le,
+    COMMUTATOR = >=,
+    NEGATOR = >,
+    RESTRICT = scalarlesel,
+    JOIN = scalarlejoinsel
+);
+
+CREATE OPERATOR > (
+    LEFTARG = celexpr,
+    RIGHTARG = celexpr,
+    FUNCTION = celexpr_gt,
+    COMMUTATOR = <,
+    NEGATOR = <=,
+    RESTRICT = scalargtsel,
+    JOIN = scalargtjoinsel
+);
+
+CREATE OPERATOR >= (
+    LEFTARG = celexpr,
+    RIGHTARG = celexpr,
+    FUNCTION = celexpr_ge,
+    COMMUTATOR = <=,
+    NEGATOR = <,
+    RESTRICT = scalargesel,
+    JOIN = scalargejoinsel
+);
+
+-- Default operator classes, so celexpr columns support ORDER BY, DISTINCT, GROUP BY and
+-- unique indexes; equal expressions are deduplicated by their canonical form
+CREATE OPERATOR CLASS celexpr_ops
+DEFAULT FOR TYPE celexpr USING btree AS
+    OPERATOR 1 <,
+    OPERATOR 2 <=,
+    OPERATOR 3 =,
+    OPERATOR 4 >=,
+    OPERATOR 5 >,
+    FUNCTION 1 celexpr_cmp(celexpr, celexpr);
+
+CREATE OPERATOR CLASS celexpr_hash_ops
+DEFAULT FOR TYPE celexpr USING hash AS
+    OPERATOR 1 =,
+    FUNCTION 1 celexpr_hash(celexpr);
+
+-- celexpr is stored like text, so it passes to every text-taking cel_* function unchanged;
+-- text becomes celexpr only through validation
+CREATE CAST (celexpr AS text) WITHOUT FUNCTION AS IMPLICIT;
+CREATE CAST (text AS celexpr) WITH FUNCTION celexpr(text) AS ASSIGNMENT;
+
+-- Function to get per-cache statistics as a JSON array
+CREATE OR REPLACE FUNCTION cel_cache_statistics_json()
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_cache_statistics_pg'
+LANGUAGE C STRICT VOLATILE;
+
+-- One row per cache of this backend; bytes are estimated memory of the live entries
+-- and max_bytes the configured size
+CREATE OR REPLACE FUNCTION cel_cache_statistics()
+RETURNS TABLE(cache text, hits bigint, misses bigint, hit_ratio double precision,
+              entries bigint, bytes bigint, max_bytes bigint, evictions bigint)
+AS $$
+    SELECT s.cache, s.hits, s.misses, s.hit_ratio, s.entries, s.bytes, s.max_bytes, s.evictions
+    FROM jsonb_to_recordset(public.cel_cache_statistics_json()::jsonb)
+         AS s(cache text, hits bigint, misses bigint, hit_ratio double precision,
+              entries bigint, bytes bigint, max_bytes bigint, evictions bigint);
+$$ LANGUAGE sql STRICT VOLATILE;
+
+-- Function to get the most frequently evaluated expressions as a JSON array
+CREATE OR REPLACE FUNCTION cel_hot_expressions_json(top_n integer)
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_hot_expressions_pg'
+LANGUAGE C STRICT VOLATILE;
+
+-- The top_n most frequently evaluated expressions of this backend (all of them when negative)
+CREATE OR REPLACE FUNCTION cel_hot_expressions(top_n integer DEFAULT 10)
+RETURNS TABLE(environment text, expression text, evaluations bigint, cache_hits bigint,
+              compilations bigint, compile_time_ms double precision,
+              total_eval_time_ms double precision, avg_eval_time_us double precision)
+AS $$
+    SELECT h.environment, h.expression, h.evaluations, h.cache_hits, h.compilations,
+           h.compile_time_ms, h.total_eval_time_ms, h.avg_eval_time_us
+    FROM jsonb_to_recordset(public.cel_hot_expressions_json(top_n)::jsonb)
+         AS h(environment text, expression text, evaluations bigint, cache_hits bigint,
+              compilations bigint, compile_time_ms double precision,
+              total_eval_time_ms double precision, avg_eval_time_us double precision);
+$$ LANGUAGE sql STRICT VOLATILE;
+
+-- Function to compile one expression into the program cache; false when it does not compile
+CREATE OR REPLACE FUNCTION cel_cache_warm_expression(expression text, declarations text)
+RETURNS boolean
+AS 'MODULE_PATHNAME', 'cel_cache_warm_pg'
+LANGUAGE C STRICT VOLATILE;
+
+-- Compile expressions for documents with the declared top-level keys ({"variable": "type"};
+-- JSON numbers are double) so the first evaluations are served from cache.
+-- Returns the number of expressions that compiled.
+CREATE OR REPLACE FUNCTION cel_cache_warm(expressions text[], declarations jsonb DEFAULT '{}')
+RETURNS integer
+AS $$
+    SELECT count(*) FILTER (WHERE public.cel_cache_warm_expression(e.expression, declarations::text))::integer
+    FROM unnest(expressions) AS e(expression)
+    WHERE e.expression IS NOT NULL;
+$$ LANGUAGE sql STRICT VOLATILE;
+
+-- Function to drop every cached program compiled for an expression, in every environment
+-- and for every document shape; returns the number of programs removed
+CREATE OR REPLACE FUNCTION cel_cache_invalidate(expression text)
+RETURNS integer
+AS 'MODULE_PATHNAME', 'cel_cache_invalidate_pg'
+LANGUAGE C STRICT VOLATILE;
+
+-- Function to drop the cached programs of every expression starting with a prefix
+CREATE OR REPLACE FUNCTION cel_cache_invalidate_prefix(prefix text)
+RETURNS integer
+AS 'MODULE_PATHNAME', 'cel_cache_invalidate_prefix_pg'
+LANGUAGE C STRICT VOLATILE;
+
+-- Function to drop the cached programs of one environment
+-- (static, document, trigger, policy or compiled)
+CREATE OR REPLACE FUNCTION cel_cache_invalidate_environment(environment text)
+RETURNS integer
+AS 'MODULE_PATHNAME', 'cel_cache_invalidate_environment_pg'
+LANGUAGE C STRICT VOLATILE;
+
+-- Function to get the cluster-wide counters as a JSON object; requires pg_cel_stats in
+-- shared_preload_libraries
+CREATE OR REPLACE FUNCTION cel_stat_json()
+RETURNS text
+AS 'MODULE_PATHNAME', 'cel_stat_pg'
+LANGUAGE C STRICT VOLATILE;
+
+-- Cache and evaluation counters of every backend since the last reset. Backends add
+-- their counts at the end of each transaction; the current one is included.
+CREATE OR REPLACE VIEW pg_stat_cel AS
+    SELECT s.program_hits, s.compilations, s.compile_time_ns / 1e6::double precision AS compile_time_ms,
+           s.json_hits, s.json_misses, s.evaluations, s.evaluation_errors,
+           s.eval_time_ns / 1e6::double precision AS eval_time_ms, s.stats_reset
+    FROM jsonb_to_record(public.cel_stat_json()::jsonb)
+         AS s(program_hits bigint, compilations bigint, compile_time_ns bigint,
+              json_hits bigint, json_misses bigint, evaluations bigint,
+              evaluation_errors bigint, eval_time_ns bigint, stats_reset timestamptz);
+
+-- Function to zero the cluster-wide counters
+CREATE OR REPLACE FUNCTION pg_stat_cel_reset()
+RETURNS void
+AS 'MODULE_PATHNAME', 'cel_stat_reset_pg'
+LANGUAGE C VOLATILE;
+
+REVOKE ALL ON FUNCTION pg_stat_cel_reset() FROM PUBLIC;
@@ -0,0 +1,79 @@
+// pg_cel_stats reserves the shared memory for the cluster-wide counters of pg_stat_cel.
+// It is plain C so it can be preloaded: pg_cel itself embeds the Go runtime, which starts
+// threads when the library is loaded and must not run in the postmaster that forks backends.
+#include "postgres.h"
+#include "fmgr.h"
+#include "miscadmin.h"
+#include "storage/ipc.h"
+#include "storage/lwlock.h"
+#include "storage/shmem.h"
+#include "utils/timestamp.h"
+#include "pg_cel_stats.h"
+
+PG_MODULE_MAGIC;
+
+static PgCelSharedStats *cel_shared_stats = NULL;
+#if PG_VERSION_NUM >= 150000
+static shmem_request_hook_type prev_shmem_request_hook = NULL;
+#endif
+static shmem_startup_hook_type prev_shmem_startup_hook = NULL;
+
+void _PG_init(void);
+
+static void pg_cel_stats_shmem_startup(void);
+#if PG_VERSION_NUM >= 150000
+static void pg_cel_stats_shmem_request(void);
+#endif
+
+void
+_PG_init(void)
+{
+    // Shared memory can only be reserved while the postmaster preloads libraries
+    if (!process_shared_preload_libraries_in_progress)
+        return;
+
+#if PG_VERSION_NUM >= 150000
+    prev_shmem_request_hook = shmem_request_hook;
+    shmem_request_hook = pg_cel_stats_shmem_request;
+#else
+    RequestAddinShmemSpace(MAXALIGN(sizeof(PgCelSharedStats)));
+#endif
+    prev_shmem_startup_hook = shmem_startup_hook;
+    shmem_startup_hook = pg_cel_stats_shmem_startup;
+
+    // Backends inherit the variable, and the counters pointer it leads to, from the postmaster
+    *find_rendezvous_variable(PG_CEL_STATS_RENDEZVOUS) = &cel_shared_stats;
+}
+
+#if PG_VERSION_NUM >= 150000
+// Reserve shared memory for the cluster-wide counters
+static void
+pg_cel_stats_shmem_request(void)
+{
+    if (prev_shmem_request_hook)
+        prev_shmem_request_hook();
+
+    RequestAddinShmemSpace(MAXALIGN(sizeof(PgCelSharedStats)));
+}
+#endif
+
+// Attach to (or create) the cluster-wide counters
+static void
+pg_cel_stats_shmem_startup(void)
+{
+    bool found;
+    int i;
+
+    if (prev_shmem_startup_hook)
+        prev_shmem_startup_hook();
+
+    LWLockAcquire(AddinShmemInitLock, LW_EXCLUSIVE);
+    cel_shared_stats = ShmemInitStruct("pg_cel statistics", sizeof(PgCelSharedStats), &found);
+    if (!found)
+    {
+        for (i = 0; i < PG_CEL_STAT_COUNT; i++)
+            pg_atomic_init_u64(&cel_shared_stats->counters[i], 0);
+        pg_atomic_init_u64(&cel_shared_stats->stats_reset, (uint64) GetCurrentTimestamp());
+    }
+    LWLockRelease(AddinShmemInitLock);
+}
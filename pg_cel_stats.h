@@ -0,0 +1,31 @@
+#ifndef PG_CEL_STATS_H
+#define PG_CEL_STATS_H
+
+#include "port/atomics.h"
+
+// Cluster-wide counters; the order must match the stat constants in stats.go
+typedef enum PgCelStat
+{
+    PG_CEL_STAT_PROGRAM_HITS,
+    PG_CEL_STAT_COMPILATIONS,
+    PG_CEL_STAT_COMPILE_NANOS,
+    PG_CEL_STAT_JSON_HITS,
+    PG_CEL_STAT_JSON_MISSES,
+    PG_CEL_STAT_EVALUATIONS,
+    PG_CEL_STAT_EVALUATION_ERRORS,
+    PG_CEL_STAT_EVAL_NANOS,
+    PG_CEL_STAT_COUNT
+} PgCelStat;
+
+// Counters of every backend, in shared memory reserved by the preloaded pg_cel_stats module
+typedef struct PgCelSharedStats
+{
+    pg_atomic_uint64 counters[PG_CEL_STAT_COUNT];
+    pg_atomic_uint64 stats_reset;   // TimestampTz of the last reset
+} PgCelSharedStats;
+
+// Rendezvous variable through which a preloaded pg_cel_stats publishes the address of
+// its PgCelSharedStats pointer; pg_cel finds it unset when the module is not preloaded
+#define PG_CEL_STATS_RENDEZVOUS "pg_cel_shared_stats"
+
+#endif // PG_CEL_STATS_H
@@ -3,28 +3,165 @@
 #include "utils/builtins.h"
 #include "utils/varlena.h"
 #include "utils/guc.h"
+#include "access/parallel.h"
+#include "access/xact.h"
+#include "executor/executor.h"
+#include "executor/spi.h"
+#include "utils/resowner.h"
+#include "miscadmin.h"
+#include "utils/timestamp.h"
+#include "common/hashfn.h"
+#include "libpq/pqformat.h"
 #include "pg_cel_go.h"
+#include "pg_cel_stats.h"
 
 PG_MODULE_MAGIC;
 
 // Configuration variables
 static int program_cache_size_mb = 128;   // Default 128MB (halved from 256MB)
 static int json_cache_size_mb = 64;       // Default 64MB (halved from 128MB)
+static char *policy_claims = NULL;        // Custom claims (JSON) exposed to CEL policies
+static bool track_coverage = false;       // Record sub-expression coverage of cached programs
+static char *document_variable = NULL;    // Variable the whole JSON document is bound to (empty: one per key)
+static bool enable_json_cache = true;     // Cache parsed JSON documents that are seen more than once
+static int json_cache_max_entry_kb = 1024; // Largest JSON document cached, in kB (0: no limit)
+static int json_cache_ttl = 0;            // Seconds a cached JSON document is kept (0: until evicted)
+static char *warm_query = NULL;           // Query returning expressions to compile when a backend loads the library
+
+// Whether the warm query still has to run in this backend, and the hook it runs from
+static bool warm_pending = false;
+static ExecutorRun_hook_type prev_ExecutorRun = NULL;
+
+// Cluster-wide counters found through the preloaded pg_cel_stats module; NULL when it
+// is not preloaded
+static PgCelSharedStats *cel_shared_stats = NULL;
 
 // Forward declarations for Go functions (these are the actual Go function names)
 extern char* pg_cel_eval(char* expression, char* data);
 extern char* pg_cel_eval_json(char* expression, char* json_data);
 extern char* pg_cel_compile_check(char* expression);
 extern void pg_init_caches(GoInt program_cache_mb, GoInt json_cache_mb);
+extern void pg_cel_resize_program_cache(GoInt program_cache_mb);
+extern void pg_cel_resize_json_cache(GoInt json_cache_mb);
 extern char* pg_cel_cache_stats(void);
 extern char* pg_cel_cache_clear(void);
+extern char* pg_cel_eval_rules(char* json_data, char* rules, char** error);
+extern char* pg_cel_decide(char* ruleset, char* json_data, char** error);
+extern long long pg_cel_agg_fold_init(char* init_expr, char** error);
+extern void pg_cel_agg_fold_step(long long handle, char* step_expr, char* json_data, char** error);
+extern char* pg_cel_agg_fold_result(long long handle, char** error);
+extern long long pg_cel_agg_count_if_init(void);
+extern void pg_cel_agg_count_if_step(long long handle, char* expression, char* json_data, char** error);
+extern long long pg_cel_agg_count_if_result(long long handle);
+extern void pg_cel_agg_release(long long handle);
+extern char* pg_cel_validate_row(char* row_data, char* rules, char** error);
+extern char* pg_cel_validate(char* json_data, char* rules, char** error);
+extern int pg_cel_eval_policy(char* expression, char* row_data, char* principal, char** error);
+extern char* pg_cel_transform(char* json_data, char* expression, char** error);
+extern char* pg_cel_project(char* json_data, char* spec, char** error);
+extern char* pg_cel_explain(char* expression, char* declarations, char** error);
+extern char* pg_cel_eval_trace(char* expression, char* json_data, char** error);
+extern void pg_cel_set_track_coverage(int enabled);
+extern void pg_cel_set_json_cache_enabled(int enabled);
+extern void pg_cel_set_json_cache_max_entry_kb(int max_entry_kb);
+extern int pg_cel_check_document_variable(char* name);
+extern void pg_cel_set_document_variable(char* name);
+extern char* pg_cel_coverage(char** error);
+extern char* pg_cel_coverage_reset(void);
+extern char* pg_cel_format(char* expression, int wrap_column, char** error);
+extern char* pg_cel_compile(char* expression, char* declarations, int* result_len, char** error);
+extern char* pg_cel_eval_compiled(char* compiled, int compiled_len, char* json_data, char** error);
+extern char* pg_cel_cache_statistics(char** error);
+extern char* pg_cel_hot_expressions(int limit, char** error);
+extern int pg_cel_cache_warm(char* expression, char* declarations, char** error);
+extern void pg_cel_set_json_cache_ttl(int seconds);
+extern void pg_cel_stats_take(long long* counters, int count);
+extern int pg_cel_cache_invalidate(char* expression);
+extern int pg_cel_cache_invalidate_prefix(char* prefix);
+extern int pg_cel_cache_invalidate_environment(char* environment, char** error);
 
 // Module initialization function
 void _PG_init(void);
 
+static void pg_cel_ExecutorRun(QueryDesc *queryDesc, ScanDirection direction, uint64 count, bool execute_once);
+static void pg_cel_xact_callback(XactEvent event, void *arg);
+
+// Resize the program cache of this backend; a shrinking cache evicts its least used programs
+static void
+assign_program_cache_size_mb(int newval, void *extra)
+{
+    pg_cel_resize_program_cache((GoInt)newval);
+}
+
+// Resize the JSON cache of this backend; it is emptied when its documents no longer fit
+static void
+assign_json_cache_size_mb(int newval, void *extra)
+{
+    pg_cel_resize_json_cache((GoInt)newval);
+}
+
+// Switch coverage instrumentation of newly compiled programs on or off
+static void
+assign_track_coverage(bool newval, void *extra)
+{
+    pg_cel_set_track_coverage(newval ? 1 : 0);
+}
+
+// Only valid, non-reserved CEL identifiers can name the document variable
+static bool
+check_document_variable(char **newval, void **extra, GucSource source)
+{
+    if (*newval != NULL && !pg_cel_check_document_variable(*newval))
+    {
+        GUC_check_errdetail("\"%s\" is not a valid CEL identifier.", *newval);
+        return false;
+    }
+    return true;
+}
+
+// Switch between per-key variables and a single document variable
+static void
+assign_document_variable(const char *newval, void *extra)
+{
+    pg_cel_set_document_variable((char *) (newval != NULL ? newval : ""));
+}
+
+// Turn the JSON document cache on or off
+static void
+assign_enable_json_cache(bool newval, void *extra)
+{
+    pg_cel_set_json_cache_enabled(newval ? 1 : 0);
+}
+
+// Change the size ceiling for cached JSON documents
+static void
+assign_json_cache_max_entry_kb(int newval, void *extra)
+{
+    pg_cel_set_json_cache_max_entry_kb(newval);
+}
+
+// Change how long cached JSON documents are kept
+static void
+assign_json_cache_ttl(int newval, void *extra)
+{
+    pg_cel_set_json_cache_ttl(newval);
+}
+
 void
 _PG_init(void)
 {
+    PgCelSharedStats **shared_stats;
+
+    // Loading the library starts the Go runtime, whose threads do not survive the fork
+    // of backends from the postmaster. It has started before _PG_init runs, so skipping
+    // initialization with a warning would still leave every backend with a broken runtime.
+    if (process_shared_preload_libraries_in_progress)
+        ereport(ERROR,
+                (errcode(ERRCODE_OBJECT_NOT_IN_PREREQUISITE_STATE),
+                 errmsg("pg_cel cannot be loaded via shared_preload_libraries"),
+                 errdetail("pg_cel embeds the Go runtime, which does not survive the postmaster forking backends."),
+                 errhint("Remove pg_cel from shared_preload_libraries; backends load it on first use. Add pg_cel_stats instead to collect cluster-wide statistics.")));
+
     // Define custom GUC parameters
     DefineCustomIntVariable("pg_cel.program_cache_size_mb",
                            "Size of CEL program cache in MB",
@@ -36,7 +173,7 @@ _PG_init(void)
                            PGC_SUSET,      // can be set by superuser
                            0,              // flags
                            NULL,           // check_hook
-                           NULL,           // assign_hook
+                           assign_program_cache_size_mb, // assign_hook
                            NULL);          // show_hook
 
     DefineCustomIntVariable("pg_cel.json_cache_size_mb",
@@ -49,11 +186,166 @@ _PG_init(void)
                            PGC_SUSET,      // can be set by superuser
                            0,              // flags
                            NULL,           // check_hook
-                           NULL,           // assign_hook
+                           assign_json_cache_size_mb, // assign_hook
                            NULL);          // show_hook
 
+    DefineCustomBoolVariable("pg_cel.enable_json_cache",
+                             "Cache parsed JSON documents",
+                             "When off, JSON documents are parsed on every evaluation; useful for workloads that never repeat a document.",
+                             &enable_json_cache,
+                             true,           // default value
+                             PGC_USERSET,    // can be set by any user
+                             0,              // flags
+                             NULL,           // check_hook
+                             assign_enable_json_cache, // assign_hook
+                             NULL);          // show_hook
+
+    DefineCustomIntVariable("pg_cel.json_cache_max_entry_kb",
+                           "Largest JSON document kept in the JSON cache",
+                           "Documents larger than this are parsed on every evaluation. 0 removes the limit.",
+                           &json_cache_max_entry_kb,
+                           1024,           // default value (1MB)
+                           0,              // min value (no limit)
+                           1048576,        // max value (1GB)
+                           PGC_SUSET,      // can be set by superuser
+                           GUC_UNIT_KB,    // flags
+                           NULL,           // check_hook
+                           assign_json_cache_max_entry_kb, // assign_hook
+                           NULL);          // show_hook
+
+    DefineCustomIntVariable("pg_cel.json_cache_ttl",
+                           "Time a parsed JSON document is kept in the JSON cache",
+                           "Documents cached after this setting changes expire this long after they are cached. 0 keeps them until evicted.",
+                           &json_cache_ttl,
+                           0,              // default value (no expiry)
+                           0,              // min value
+                           INT_MAX,        // max value
+                           PGC_USERSET,    // can be set by any user
+                           GUC_UNIT_S,     // flags
+                           NULL,           // check_hook
+                           assign_json_cache_ttl, // assign_hook
+                           NULL);          // show_hook
+
+    DefineCustomStringVariable("pg_cel.warm_query",
+                               "Query returning CEL expressions to compile when a backend loads pg_cel",
+                               "The first column holds expressions; an optional second column holds their variable declarations as a JSON object. The query runs before the first statement that needs it.",
+                               &warm_query,
+                               "",             // default value
+                               PGC_SUSET,      // can be set by superuser
+                               0,              // flags
+                               NULL,           // check_hook
+                               NULL,           // assign_hook
+                               NULL);          // show_hook
+
+    DefineCustomStringVariable("pg_cel.claims",
+                               "Custom claims for CEL row-level security policies",
+                               "JSON object exposed to CEL policies as principal.claims.",
+                               &policy_claims,
+                               "",             // default value
+                               PGC_USERSET,    // can be set by any user
+                               0,              // flags
+                               NULL,           // check_hook
+                               NULL,           // assign_hook
+                               NULL);          // show_hook
+
+    DefineCustomBoolVariable("pg_cel.track_coverage",
+                             "Record which sub-expressions of CEL programs are evaluated",
+                             "When on, programs compiled in this session count how often each sub-expression runs; see cel_coverage().",
+                             &track_coverage,
+                             false,          // default value
+                             PGC_SUSET,      // can be set by superuser
+                             0,              // flags
+                             NULL,           // check_hook
+                             assign_track_coverage, // assign_hook
+                             NULL);          // show_hook
+
+    DefineCustomStringVariable("pg_cel.document_variable",
+                               "Variable the whole JSON document is bound to",
+                               "When empty, each top-level key of a JSON object is a CEL variable. When set (e.g. to doc), the whole document, which may also be an array or scalar, is bound to this one variable.",
+                               &document_variable,
+                               "",             // default value
+                               PGC_USERSET,    // can be set by any user
+                               0,              // flags
+                               check_document_variable, // check_hook
+                               assign_document_variable, // assign_hook
+                               NULL);          // show_hook
+
     // Initialize Go caches with configured values
     pg_init_caches((GoInt)program_cache_size_mb, (GoInt)json_cache_size_mb);
+
+    // Warm the program cache before the first statement runs. Libraries are loaded while
+    // the executor of the statement that first needs them starts, so the hook is on
+    // ExecutorRun: it still fires for that statement, before any of its rows are evaluated.
+    warm_pending = warm_query != NULL && warm_query[0] != '\0';
+    prev_ExecutorRun = ExecutorRun_hook;
+    ExecutorRun_hook = pg_cel_ExecutorRun;
+
+    // Cluster-wide statistics live in shared memory reserved by the pg_cel_stats module.
+    // It is not loaded from here: statistics are optional, and only a preloaded module has
+    // counters, which it publishes through a rendezvous variable. Otherwise pg_stat_cel
+    // reports an error.
+    shared_stats = (PgCelSharedStats **) *find_rendezvous_variable(PG_CEL_STATS_RENDEZVOUS);
+    cel_shared_stats = shared_stats != NULL ? *shared_stats : NULL;
+    RegisterXactCallback(pg_cel_xact_callback, NULL);
+}
+
+// Raise a PostgreSQL error for a message reported by a Go function
+static void
+report_go_error(char *error)
+{
+    char *message;
+
+    if (error == NULL)
+        return;
+
+    // Copy into palloc'd memory so the Go-allocated string can be freed before longjmp
+    message = pstrdup(error);
+    free(error);
+
+    ereport(ERROR,
+            (errcode(ERRCODE_DATA_EXCEPTION),
+             errmsg("%s", message)));
+}
+
+// Convert a Go-allocated result string to text and release the original
+static text *
+go_result_to_text(char *result)
+{
+    text *result_text = cstring_to_text(result);
+
+    free(result);
+    return result_text;
+}
+
+// Aggregate transition state: handle of the accumulator kept on the Go side
+typedef struct CelAggState
+{
+    long long handle;
+    MemoryContextCallback release_callback;
+} CelAggState;
+
+// Release the Go-side accumulator when the aggregate memory context is reset or deleted
+static void
+cel_agg_state_release(void *arg)
+{
+    CelAggState *state = (CelAggState *) arg;
+
+    pg_cel_agg_release(state->handle);
+}
+
+// Wrap a Go accumulator handle in a state allocated in the aggregate memory context
+static CelAggState *
+cel_agg_state_create(MemoryContext aggcontext, long long handle)
+{
+    CelAggState *state;
+
+    state = (CelAggState *) MemoryContextAllocZero(aggcontext, sizeof(CelAggState));
+    state->handle = handle;
+    state->release_callback.func = cel_agg_state_release;
+    state->release_callback.arg = state;
+    MemoryContextRegisterResetCallback(aggcontext, &state->release_callback);
+
+    return state;
 }
 
 // PostgreSQL function wrappers (using different names to avoid conflicts)
@@ -62,6 +354,45 @@ PG_FUNCTION_INFO_V1(cel_eval_json_pg);
 PG_FUNCTION_INFO_V1(cel_compile_check_pg);
 PG_FUNCTION_INFO_V1(cel_cache_stats_pg);
 PG_FUNCTION_INFO_V1(cel_cache_clear_pg);
+PG_FUNCTION_INFO_V1(cel_eval_rules_pg);
+PG_FUNCTION_INFO_V1(cel_decide_pg);
+PG_FUNCTION_INFO_V1(cel_agg_fold_transfn_pg);
+PG_FUNCTION_INFO_V1(cel_agg_fold_finalfn_pg);
+PG_FUNCTION_INFO_V1(cel_count_if_transfn_pg);
+PG_FUNCTION_INFO_V1(cel_count_if_finalfn_pg);
+PG_FUNCTION_INFO_V1(cel_validate_row_pg);
+PG_FUNCTION_INFO_V1(cel_validate_pg);
+PG_FUNCTION_INFO_V1(cel_policy_pg);
+PG_FUNCTION_INFO_V1(cel_transform_pg);
+PG_FUNCTION_INFO_V1(cel_project_pg);
+PG_FUNCTION_INFO_V1(cel_explain_pg);
+PG_FUNCTION_INFO_V1(cel_eval_trace_pg);
+PG_FUNCTION_INFO_V1(cel_coverage_pg);
+PG_FUNCTION_INFO_V1(cel_coverage_reset_pg);
+PG_FUNCTION_INFO_V1(cel_format_pg);
+PG_FUNCTION_INFO_V1(cel_compile_pg);
+PG_FUNCTION_INFO_V1(cel_eval_compiled_pg);
+PG_FUNCTION_INFO_V1(cel_cache_statistics_pg);
+PG_FUNCTION_INFO_V1(cel_hot_expressions_pg);
+PG_FUNCTION_INFO_V1(cel_cache_warm_pg);
+PG_FUNCTION_INFO_V1(cel_cache_invalidate_pg);
+PG_FUNCTION_INFO_V1(cel_cache_invalidate_prefix_pg);
+PG_FUNCTION_INFO_V1(cel_cache_invalidate_environment_pg);
+PG_FUNCTION_INFO_V1(cel_stat_pg);
+PG_FUNCTION_INFO_V1(cel_stat_reset_pg);
+PG_FUNCTION_INFO_V1(celexpr_in);
+PG_FUNCTION_INFO_V1(celexpr_out);
+PG_FUNCTION_INFO_V1(celexpr_from_text);
+PG_FUNCTION_INFO_V1(celexpr_eq);
+PG_FUNCTION_INFO_V1(celexpr_ne);
+PG_FUNCTION_INFO_V1(celexpr_lt);
+PG_FUNCTION_INFO_V1(celexpr_le);
+PG_FUNCTION_INFO_V1(celexpr_gt);
+PG_FUNCTION_INFO_V1(celexpr_ge);
+PG_FUNCTION_INFO_V1(celexpr_cmp);
+PG_FUNCTION_INFO_V1(celexpr_hash);
+PG_FUNCTION_INFO_V1(celexpr_recv);
+PG_FUNCTION_INFO_V1(celexpr_send);
 
 Datum
 cel_eval_pg(PG_FUNCTION_ARGS)
@@ -123,3 +454,773 @@ cel_cache_clear_pg(PG_FUNCTION_ARGS)
 
     PG_RETURN_TEXT_P(cstring_to_text(result));
 }
+
+Datum
+cel_eval_rules_pg(PG_FUNCTION_ARGS)
+{
+    text *json_data = PG_GETARG_TEXT_PP(0);
+    text *rules = PG_GETARG_TEXT_PP(1);
+
+    char *json_str = text_to_cstring(json_data);
+    char *rules_str = text_to_cstring(rules);
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_eval_rules(json_str, rules_str, &error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_decide_pg(PG_FUNCTION_ARGS)
+{
+    text *ruleset = PG_GETARG_TEXT_PP(0);
+    text *json_data = PG_GETARG_TEXT_PP(1);
+
+    char *ruleset_str = text_to_cstring(ruleset);
+    char *json_str = text_to_cstring(json_data);
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_decide(ruleset_str, json_str, &error);
+    report_go_error(error);
+
+    // No matching rule and no default
+    if (result == NULL)
+        PG_RETURN_NULL();
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_agg_fold_transfn_pg(PG_FUNCTION_ARGS)
+{
+    MemoryContext aggcontext;
+    CelAggState *state;
+    char *error = NULL;
+
+    if (!AggCheckCallContext(fcinfo, &aggcontext))
+        elog(ERROR, "cel_agg_fold_transfn called in non-aggregate context");
+
+    if (PG_ARGISNULL(1) || PG_ARGISNULL(2))
+        ereport(ERROR,
+                (errcode(ERRCODE_NULL_VALUE_NOT_ALLOWED),
+                 errmsg("cel_agg_fold expressions must not be NULL")));
+
+    if (PG_ARGISNULL(0))
+    {
+        char *init_str = text_to_cstring(PG_GETARG_TEXT_PP(1));
+
+        // First row of the group: evaluate the initial accumulator in Go
+        long long handle = pg_cel_agg_fold_init(init_str, &error);
+        report_go_error(error);
+
+        state = cel_agg_state_create(aggcontext, handle);
+    }
+    else
+        state = (CelAggState *) PG_GETARG_POINTER(0);
+
+    // NULL documents are skipped, like NULL inputs to built-in aggregates
+    if (!PG_ARGISNULL(3))
+    {
+        char *step_str = text_to_cstring(PG_GETARG_TEXT_PP(2));
+        char *json_str = DatumGetCString(DirectFunctionCall1(jsonb_out, PG_GETARG_DATUM(3)));
+
+        // Call the Go function
+        pg_cel_agg_fold_step(state->handle, step_str, json_str, &error);
+        report_go_error(error);
+    }
+
+    PG_RETURN_POINTER(state);
+}
+
+Datum
+cel_agg_fold_finalfn_pg(PG_FUNCTION_ARGS)
+{
+    CelAggState *state;
+    char *error = NULL;
+    char *result;
+    Datum jsonb;
+
+    // No input rows
+    if (PG_ARGISNULL(0))
+        PG_RETURN_NULL();
+
+    state = (CelAggState *) PG_GETARG_POINTER(0);
+
+    // Call the Go function
+    result = pg_cel_agg_fold_result(state->handle, &error);
+    report_go_error(error);
+
+    jsonb = DirectFunctionCall1(jsonb_in, CStringGetDatum(result));
+    free(result);
+
+    PG_RETURN_DATUM(jsonb);
+}
+
+Datum
+cel_count_if_transfn_pg(PG_FUNCTION_ARGS)
+{
+    MemoryContext aggcontext;
+    CelAggState *state;
+    char *error = NULL;
+
+    if (!AggCheckCallContext(fcinfo, &aggcontext))
+        elog(ERROR, "cel_count_if_transfn called in non-aggregate context");
+
+    if (PG_ARGISNULL(1))
+        ereport(ERROR,
+                (errcode(ERRCODE_NULL_VALUE_NOT_ALLOWED),
+                 errmsg("cel_count_if expression must not be NULL")));
+
+    if (PG_ARGISNULL(0))
+        state = cel_agg_state_create(aggcontext, pg_cel_agg_count_if_init());
+    else
+        state = (CelAggState *) PG_GETARG_POINTER(0);
+
+    // NULL documents are not counted
+    if (!PG_ARGISNULL(2))
+    {
+        char *expr_str = text_to_cstring(PG_GETARG_TEXT_PP(1));
+        char *json_str = DatumGetCString(DirectFunctionCall1(jsonb_out, PG_GETARG_DATUM(2)));
+
+        // Call the Go function
+        pg_cel_agg_count_if_step(state->handle, expr_str, json_str, &error);
+        report_go_error(error);
+    }
+
+    PG_RETURN_POINTER(state);
+}
+
+Datum
+cel_count_if_finalfn_pg(PG_FUNCTION_ARGS)
+{
+    CelAggState *state;
+
+    // No input rows counts as zero, like count(*)
+    if (PG_ARGISNULL(0))
+        PG_RETURN_INT64(0);
+
+    state = (CelAggState *) PG_GETARG_POINTER(0);
+
+    PG_RETURN_INT64(pg_cel_agg_count_if_result(state->handle));
+}
+
+Datum
+cel_validate_row_pg(PG_FUNCTION_ARGS)
+{
+    text *row_data = PG_GETARG_TEXT_PP(0);
+    text *rules = PG_GETARG_TEXT_PP(1);
+
+    char *row_str = text_to_cstring(row_data);
+    char *rules_str = text_to_cstring(rules);
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_validate_row(row_str, rules_str, &error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_validate_pg(PG_FUNCTION_ARGS)
+{
+    text *json_data = PG_GETARG_TEXT_PP(0);
+    text *rules = PG_GETARG_TEXT_PP(1);
+
+    char *json_str = text_to_cstring(json_data);
+    char *rules_str = text_to_cstring(rules);
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_validate(json_str, rules_str, &error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_policy_pg(PG_FUNCTION_ARGS)
+{
+    text *expression = PG_GETARG_TEXT_PP(0);
+    text *row_data = PG_GETARG_TEXT_PP(1);
+    text *principal = PG_GETARG_TEXT_PP(2);
+
+    char *expr_str = text_to_cstring(expression);
+    char *row_str = text_to_cstring(row_data);
+    char *principal_str = text_to_cstring(principal);
+    char *error = NULL;
+
+    // Call the Go function
+    int allowed = pg_cel_eval_policy(expr_str, row_str, principal_str, &error);
+    report_go_error(error);
+
+    PG_RETURN_BOOL(allowed != 0);
+}
+
+Datum
+cel_transform_pg(PG_FUNCTION_ARGS)
+{
+    text *json_data = PG_GETARG_TEXT_PP(0);
+    text *expression = PG_GETARG_TEXT_PP(1);
+
+    char *json_str = text_to_cstring(json_data);
+    char *expr_str = text_to_cstring(expression);
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_transform(json_str, expr_str, &error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_project_pg(PG_FUNCTION_ARGS)
+{
+    text *json_data = PG_GETARG_TEXT_PP(0);
+    text *spec = PG_GETARG_TEXT_PP(1);
+
+    char *json_str = text_to_cstring(json_data);
+    char *spec_str = text_to_cstring(spec);
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_project(json_str, spec_str, &error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_explain_pg(PG_FUNCTION_ARGS)
+{
+    text *expression = PG_GETARG_TEXT_PP(0);
+    text *declarations = PG_GETARG_TEXT_PP(1);
+
+    char *expr_str = text_to_cstring(expression);
+    char *decl_str = text_to_cstring(declarations);
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_explain(expr_str, decl_str, &error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_eval_trace_pg(PG_FUNCTION_ARGS)
+{
+    text *expression = PG_GETARG_TEXT_PP(0);
+    text *json_data = PG_GETARG_TEXT_PP(1);
+
+    char *expr_str = text_to_cstring(expression);
+    char *json_str = text_to_cstring(json_data);
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_eval_trace(expr_str, json_str, &error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_coverage_pg(PG_FUNCTION_ARGS)
+{
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_coverage(&error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_coverage_reset_pg(PG_FUNCTION_ARGS)
+{
+    // Call the Go function
+    char *result = pg_cel_coverage_reset();
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_format_pg(PG_FUNCTION_ARGS)
+{
+    text *expression = PG_GETARG_TEXT_PP(0);
+    int32 wrap_column = PG_GETARG_INT32(1);
+
+    char *expr_str = text_to_cstring(expression);
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_format(expr_str, wrap_column, &error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_compile_pg(PG_FUNCTION_ARGS)
+{
+    text *expression = PG_GETARG_TEXT_PP(0);
+    text *declarations = PG_GETARG_TEXT_PP(1);
+
+    char *expr_str = text_to_cstring(expression);
+    char *decl_str = text_to_cstring(declarations);
+    char *error = NULL;
+    int result_len = 0;
+    bytea *compiled;
+
+    // Call the Go function
+    char *result = pg_cel_compile(expr_str, decl_str, &result_len, &error);
+    report_go_error(error);
+
+    // Copy the serialized checked expression into a bytea and release the Go buffer
+    compiled = (bytea *) palloc(VARHDRSZ + result_len);
+    SET_VARSIZE(compiled, VARHDRSZ + result_len);
+    memcpy(VARDATA(compiled), result, result_len);
+    free(result);
+
+    PG_RETURN_BYTEA_P(compiled);
+}
+
+Datum
+cel_eval_compiled_pg(PG_FUNCTION_ARGS)
+{
+    bytea *compiled = PG_GETARG_BYTEA_PP(0);
+    text *json_data = PG_GETARG_TEXT_PP(1);
+
+    char *json_str = text_to_cstring(json_data);
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_eval_compiled(VARDATA_ANY(compiled), (int) VARSIZE_ANY_EXHDR(compiled), json_str, &error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+// Parse an expression and return its canonical form as a celexpr value; invalid CEL is rejected
+static text *
+celexpr_normalize(char *expr_str)
+{
+    char *error = NULL;
+    char *message;
+    char *result = pg_cel_format(expr_str, 0, &error);
+
+    if (error != NULL)
+    {
+        message = pstrdup(error);
+        free(error);
+
+        ereport(ERROR,
+                (errcode(ERRCODE_INVALID_TEXT_REPRESENTATION),
+                 errmsg("invalid input syntax for type celexpr: \"%s\"", expr_str),
+                 errdetail("%s", message)));
+    }
+
+    return go_result_to_text(result);
+}
+
+// celexpr values are stored in canonical form, so equality is a byte comparison
+static bool
+celexpr_equal(text *a, text *b)
+{
+    Size len_a = VARSIZE_ANY_EXHDR(a);
+    Size len_b = VARSIZE_ANY_EXHDR(b);
+
+    return len_a == len_b && memcmp(VARDATA_ANY(a), VARDATA_ANY(b), len_a) == 0;
+}
+
+// Order celexpr values byte-wise (like the C collation), so sorting and btree indexes do
+// not depend on the database locale
+static int
+celexpr_compare(text *a, text *b)
+{
+    Size len_a = VARSIZE_ANY_EXHDR(a);
+    Size len_b = VARSIZE_ANY_EXHDR(b);
+    int result = memcmp(VARDATA_ANY(a), VARDATA_ANY(b), Min(len_a, len_b));
+
+    if (result != 0)
+        return result < 0 ? -1 : 1;
+    if (len_a != len_b)
+        return len_a < len_b ? -1 : 1;
+    return 0;
+}
+
+Datum
+celexpr_in(PG_FUNCTION_ARGS)
+{
+    char *expr_str = PG_GETARG_CSTRING(0);
+
+    PG_RETURN_TEXT_P(celexpr_normalize(expr_str));
+}
+
+Datum
+celexpr_out(PG_FUNCTION_ARGS)
+{
+    text *expression = PG_GETARG_TEXT_PP(0);
+
+    PG_RETURN_CSTRING(text_to_cstring(expression));
+}
+
+Datum
+celexpr_from_text(PG_FUNCTION_ARGS)
+{
+    text *expression = PG_GETARG_TEXT_PP(0);
+
+    PG_RETURN_TEXT_P(celexpr_normalize(text_to_cstring(expression)));
+}
+
+Datum
+celexpr_eq(PG_FUNCTION_ARGS)
+{
+    text *a = PG_GETARG_TEXT_PP(0);
+    text *b = PG_GETARG_TEXT_PP(1);
+
+    PG_RETURN_BOOL(celexpr_equal(a, b));
+}
+
+Datum
+celexpr_ne(PG_FUNCTION_ARGS)
+{
+    text *a = PG_GETARG_TEXT_PP(0);
+    text *b = PG_GETARG_TEXT_PP(1);
+
+    PG_RETURN_BOOL(!celexpr_equal(a, b));
+}
+
+Datum
+celexpr_lt(PG_FUNCTION_ARGS)
+{
+    text *a = PG_GETARG_TEXT_PP(0);
+    text *b = PG_GETARG_TEXT_PP(1);
+
+    PG_RETURN_BOOL(celexpr_compare(a, b) < 0);
+}
+
+Datum
+celexpr_le(PG_FUNCTION_ARGS)
+{
+    text *a = PG_GETARG_TEXT_PP(0);
+    text *b = PG_GETARG_TEXT_PP(1);
+
+    PG_RETURN_BOOL(celexpr_compare(a, b) <= 0);
+}
+
+Datum
+celexpr_gt(PG_FUNCTION_ARGS)
+{
+    text *a = PG_GETARG_TEXT_PP(0);
+    text *b = PG_GETARG_TEXT_PP(1);
+
+    PG_RETURN_BOOL(celexpr_compare(a, b) > 0);
+}
+
+Datum
+celexpr_ge(PG_FUNCTION_ARGS)
+{
+    text *a = PG_GETARG_TEXT_PP(0);
+    text *b = PG_GETARG_TEXT_PP(1);
+
+    PG_RETURN_BOOL(celexpr_compare(a, b) >= 0);
+}
+
+Datum
+celexpr_cmp(PG_FUNCTION_ARGS)
+{
+    text *a = PG_GETARG_TEXT_PP(0);
+    text *b = PG_GETARG_TEXT_PP(1);
+
+    PG_RETURN_INT32(celexpr_compare(a, b));
+}
+
+// Hash the canonical bytes, consistent with celexpr_equal
+Datum
+celexpr_hash(PG_FUNCTION_ARGS)
+{
+    text *expression = PG_GETARG_TEXT_PP(0);
+
+    return hash_any((unsigned char *) VARDATA_ANY(expression), (int) VARSIZE_ANY_EXHDR(expression));
+}
+
+// Binary input is the expression text, validated and normalized like text input
+Datum
+celexpr_recv(PG_FUNCTION_ARGS)
+{
+    StringInfo buf = (StringInfo) PG_GETARG_POINTER(0);
+    int nbytes;
+    char *expr_str = pq_getmsgtext(buf, buf->len - buf->cursor, &nbytes);
+
+    PG_RETURN_TEXT_P(celexpr_normalize(expr_str));
+}
+
+// Binary output is the canonical expression text, as for text
+Datum
+celexpr_send(PG_FUNCTION_ARGS)
+{
+    text *expression = PG_GETARG_TEXT_PP(0);
+    StringInfoData buf;
+
+    pq_begintypsend(&buf);
+    pq_sendtext(&buf, VARDATA_ANY(expression), VARSIZE_ANY_EXHDR(expression));
+    PG_RETURN_BYTEA_P(pq_endtypsend(&buf));
+}
+
+Datum
+cel_cache_statistics_pg(PG_FUNCTION_ARGS)
+{
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_cache_statistics(&error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+Datum
+cel_hot_expressions_pg(PG_FUNCTION_ARGS)
+{
+    int32 limit = PG_GETARG_INT32(0);
+    char *error = NULL;
+
+    // Call the Go function
+    char *result = pg_cel_hot_expressions(limit, &error);
+    report_go_error(error);
+
+    PG_RETURN_TEXT_P(go_result_to_text(result));
+}
+
+// Compile the expressions returned by pg_cel.warm_query into the program cache.
+// Failures are reported as a warning so that a broken warm query never blocks statements.
+static void
+warm_cache_from_query(void)
+{
+    MemoryContext oldcontext = CurrentMemoryContext;
+    ResourceOwner oldowner = CurrentResourceOwner;
+
+    BeginInternalSubTransaction(NULL);
+    MemoryContextSwitchTo(oldcontext);
+
+    PG_TRY();
+    {
+        uint64 row;
+        int warmed = 0;
+
+        if (SPI_connect() != SPI_OK_CONNECT)
+            elog(ERROR, "SPI_connect failed");
+        if (SPI_execute(warm_query, true, 0) != SPI_OK_SELECT)
+            ereport(ERROR,
+                    (errcode(ERRCODE_INVALID_PARAMETER_VALUE),
+                     errmsg("pg_cel.warm_query must be a query returning expressions")));
+
+        for (row = 0; row < SPI_processed; row++)
+        {
+            HeapTuple tuple = SPI_tuptable->vals[row];
+            TupleDesc tupdesc = SPI_tuptable->tupdesc;
+            char *expression = SPI_getvalue(tuple, tupdesc, 1);
+            char *declarations = tupdesc->natts > 1 ? SPI_getvalue(tuple, tupdesc, 2) : NULL;
+            char *error = NULL;
+
+            if (expression == NULL)
+                continue;
+
+            // Call the Go function
+            warmed += pg_cel_cache_warm(expression, declarations != NULL ? declarations : "", &error);
+            report_go_error(error);
+        }
+
+        elog(DEBUG1, "pg_cel: warmed %d of " UINT64_FORMAT " expressions", warmed, SPI_processed);
+
+        SPI_finish();
+        ReleaseCurrentSubTransaction();
+        MemoryContextSwitchTo(oldcontext);
+        CurrentResourceOwner = oldowner;
+    }
+    PG_CATCH();
+    {
+        ErrorData *edata;
+
+        MemoryContextSwitchTo(oldcontext);
+        edata = CopyErrorData();
+        FlushErrorState();
+
+        RollbackAndReleaseCurrentSubTransaction();
+        MemoryContextSwitchTo(oldcontext);
+        CurrentResourceOwner = oldowner;
+
+        ereport(WARNING,
+                (errmsg("pg_cel.warm_query failed: %s", edata->message)));
+        FreeErrorData(edata);
+    }
+    PG_END_TRY();
+}
+
+// Run the warm query once per backend, before the first statement it executes
+static void
+pg_cel_ExecutorRun(QueryDesc *queryDesc, ScanDirection direction, uint64 count, bool execute_once)
+{
+    if (warm_pending && !IsParallelWorker())
+    {
+        // Cleared first: the warm query itself runs executors
+        warm_pending = false;
+        warm_cache_from_query();
+    }
+
+    if (prev_ExecutorRun)
+        prev_ExecutorRun(queryDesc, direction, count, execute_once);
+    else
+        standard_ExecutorRun(queryDesc, direction, count, execute_once);
+}
+
+Datum
+cel_cache_warm_pg(PG_FUNCTION_ARGS)
+{
+    text *expression = PG_GETARG_TEXT_PP(0);
+    text *declarations = PG_GETARG_TEXT_PP(1);
+
+    char *expr_str = text_to_cstring(expression);
+    char *decl_str = text_to_cstring(declarations);
+    char *error = NULL;
+
+    // Call the Go function
+    int warmed = pg_cel_cache_warm(expr_str, decl_str, &error);
+    report_go_error(error);
+
+    PG_RETURN_BOOL(warmed != 0);
+}
+
+Datum
+cel_cache_invalidate_pg(PG_FUNCTION_ARGS)
+{
+    text *expression = PG_GETARG_TEXT_PP(0);
+
+    char *expr_str = text_to_cstring(expression);
+
+    // Call the Go function
+    PG_RETURN_INT32(pg_cel_cache_invalidate(expr_str));
+}
+
+Datum
+cel_cache_invalidate_prefix_pg(PG_FUNCTION_ARGS)
+{
+    text *prefix = PG_GETARG_TEXT_PP(0);
+
+    char *prefix_str = text_to_cstring(prefix);
+
+    // Call the Go function
+    PG_RETURN_INT32(pg_cel_cache_invalidate_prefix(prefix_str));
+}
+
+Datum
+cel_cache_invalidate_environment_pg(PG_FUNCTION_ARGS)
+{
+    text *environment = PG_GETARG_TEXT_PP(0);
+
+    char *environment_str = text_to_cstring(environment);
+    char *error = NULL;
+
+    // Call the Go function
+    int invalidated = pg_cel_cache_invalidate_environment(environment_str, &error);
+    report_go_error(error);
+
+    PG_RETURN_INT32(invalidated);
+}
+
+// Add the counts of this backend since the last flush to the cluster-wide counters
+static void
+flush_stats(void)
+{
+    long long pending[PG_CEL_STAT_COUNT];
+    int i;
+
+    if (cel_shared_stats == NULL)
+        return;
+
+    // Call the Go function
+    pg_cel_stats_take(pending, PG_CEL_STAT_COUNT);
+    for (i = 0; i < PG_CEL_STAT_COUNT; i++)
+    {
+        if (pending[i] != 0)
+            pg_atomic_fetch_add_u64(&cel_shared_stats->counters[i], (int64) pending[i]);
+    }
+}
+
+// Flush counters at the end of every transaction, like the cumulative statistics system
+static void
+pg_cel_xact_callback(XactEvent event, void *arg)
+{
+    switch (event)
+    {
+        case XACT_EVENT_COMMIT:
+        case XACT_EVENT_ABORT:
+        case XACT_EVENT_PARALLEL_COMMIT:
+        case XACT_EVENT_PARALLEL_ABORT:
+            flush_stats();
+            break;
+        default:
+            break;
+    }
+}
+
+static void
+require_shared_stats(void)
+{
+    if (cel_shared_stats == NULL)
+        ereport(ERROR,
+                (errcode(ERRCODE_FEATURE_NOT_SUPPORTED),
+                 errmsg("pg_cel_stats must be loaded via shared_preload_libraries to collect cluster-wide statistics")));
+}
+
+Datum
+cel_stat_pg(PG_FUNCTION_ARGS)
+{
+    PgCelSharedStats *stats = cel_shared_stats;
+    TimestampTz stats_reset;
+
+    require_shared_stats();
+
+    // Include this backend's counts so far
+    flush_stats();
+
+    stats_reset = (TimestampTz) pg_atomic_read_u64(&stats->stats_reset);
+    PG_RETURN_TEXT_P(cstring_to_text(psprintf(
+        "{\"program_hits\": " UINT64_FORMAT ", \"compilations\": " UINT64_FORMAT
+        ", \"compile_time_ns\": " UINT64_FORMAT ", \"json_hits\": " UINT64_FORMAT
+        ", \"json_misses\": " UINT64_FORMAT ", \"evaluations\": " UINT64_FORMAT
+        ", \"evaluation_errors\": " UINT64_FORMAT ", \"eval_time_ns\": " UINT64_FORMAT
+        ", \"stats_reset\": \"%s\"}",
+        pg_atomic_read_u64(&stats->counters[PG_CEL_STAT_PROGRAM_HITS]),
+        pg_atomic_read_u64(&stats->counters[PG_CEL_STAT_COMPILATIONS]),
+        pg_atomic_read_u64(&stats->counters[PG_CEL_STAT_COMPILE_NANOS]),
+        pg_atomic_read_u64(&stats->counters[PG_CEL_STAT_JSON_HITS]),
+        pg_atomic_read_u64(&stats->counters[PG_CEL_STAT_JSON_MISSES]),
+        pg_atomic_read_u64(&stats->counters[PG_CEL_STAT_EVALUATIONS]),
+        pg_atomic_read_u64(&stats->counters[PG_CEL_STAT_EVALUATION_ERRORS]),
+        pg_atomic_read_u64(&stats->counters[PG_CEL_STAT_EVAL_NANOS]),
+        timestamptz_to_str(stats_reset))));
+}
+
+Datum
+cel_stat_reset_pg(PG_FUNCTION_ARGS)
+{
+    long long pending[PG_CEL_STAT_COUNT];
+    int i;
+
+    require_shared_stats();
+
+    // Discard this backend's unflushed counts along with the shared ones
+    pg_cel_stats_take(pending, PG_CEL_STAT_COUNT);
+    for (i = 0; i < PG_CEL_STAT_COUNT; i++)
+        pg_atomic_write_u64(&cel_shared_stats->counters[i], 0);
+    pg_atomic_write_u64(&cel_shared_stats->stats_reset, (uint64) GetCurrentTimestamp());
+
+    PG_RETURN_VOID();
+}
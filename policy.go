@@ -0,0 +1,100 @@
+package main
+
+import "C"
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+)
+
+// createPolicyCELEnv creates a CEL environment for row-level security policies.
+// Policies see the row being checked and the session principal
+// ({"user", "session_user", "roles", "claims"}).
+func createPolicyCELEnv() (*cel.Env, error) {
+	return cel.NewEnv(append(celExtensionOptions(),
+		cel.Variable("row", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("principal", cel.MapType(cel.StringType, cel.DynType)),
+	)...)
+}
+
+// getPolicyProgram returns the compiled program for a policy. The environment is
+// fixed, so each policy text is compiled once per backend and then served from cache.
+func getPolicyProgram(exprString string) (cel.Program, error) {
+	cacheKey := programCacheKey(policyEnvironment, exprString)
+
+	// Try to get compiled program from cache
+	if cachedProgram, found := programCache.Get(cacheKey); found {
+		recordCacheHit(cachedProgram)
+		return cachedProgram, nil
+	}
+
+	started := time.Now()
+	celEnv, err := createPolicyCELEnv()
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %v", err)
+	}
+
+	// Compile the expression (cache miss)
+	ast, issues := celEnv.Compile(exprString)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("CEL compilation error: %v", issues.Err())
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("CEL policy error: policy must evaluate to bool, got %s", ast.OutputType())
+	}
+
+	prg, err := newProgram(celEnv, ast, exprString)
+	if err != nil {
+		return nil, fmt.Errorf("CEL program creation error: %v", err)
+	}
+	prg = profileProgram(policyEnvironment, exprString, prg, started)
+
+	// Cache the compiled program
+	cacheProgram(cacheKey, policyEnvironment, exprString, prg, programCost(ast))
+
+	return prg, nil
+}
+
+//export pg_cel_eval_policy
+func pg_cel_eval_policy(expressionStr *C.char, rowData *C.char, principalData *C.char, errorOut **C.char) C.int {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	// Convert C strings to Go strings
+	exprString := C.GoString(expressionStr)
+	rowString := C.GoString(rowData)
+	principalString := C.GoString(principalData)
+
+	// Invalid policies are reported rather than silently denying every row
+	prg, err := getPolicyProgram(exprString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return 0
+	}
+
+	row, err := parseJSONData(rowString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return 0
+	}
+
+	// The principal is the same for every row of a query, so it goes through the JSON cache too
+	principal, err := parseJSONData(principalString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return 0
+	}
+
+	// Runtime errors (missing claims, absent fields) deny access
+	out, _, err := prg.Eval(map[string]any{"row": row, "principal": principal})
+	if err != nil {
+		return 0
+	}
+	if allowed, ok := out.(types.Bool); ok && bool(allowed) {
+		return 1
+	}
+	return 0
+}
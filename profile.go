@@ -0,0 +1,240 @@
+package main
+
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/ristretto/v2"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// maxProfiledExpressions bounds how many expressions are profiled per backend;
+// expressions compiled once the limit is reached are not profiled
+const maxProfiledExpressions = 10000
+
+// expressionProfile counts how often an expression is served from cache and evaluated,
+// and the time spent compiling and evaluating it, across all documents it ran against
+type expressionProfile struct {
+	environment  celEnvironment
+	expression   string
+	cacheHits    atomic.Int64
+	compilations atomic.Int64
+	evaluations  atomic.Int64
+	compileNanos atomic.Int64
+	evalNanos    atomic.Int64
+}
+
+// hotExpression is one row of the hot-expression report
+type hotExpression struct {
+	Environment     string  `json:"environment"`
+	Expression      string  `json:"expression"`
+	Evaluations     int64   `json:"evaluations"`
+	CacheHits       int64   `json:"cache_hits"`
+	Compilations    int64   `json:"compilations"`
+	CompileTimeMs   float64 `json:"compile_time_ms"`
+	TotalEvalTimeMs float64 `json:"total_eval_time_ms"`
+	AvgEvalTimeUs   float64 `json:"avg_eval_time_us"`
+}
+
+// cacheStatistics is one row of the per-cache report
+type cacheStatistics struct {
+	Cache     string  `json:"cache"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	HitRatio  float64 `json:"hit_ratio"`
+	Entries   uint64  `json:"entries"`
+	Bytes     uint64  `json:"bytes"`
+	MaxBytes  int64   `json:"max_bytes"`
+	Evictions uint64  `json:"evictions"`
+}
+
+// Expression profiles of this backend, keyed by environment and expression text
+var (
+	profilesMu sync.Mutex
+	profiles   = make(map[string]*expressionProfile)
+)
+
+// profileFor returns the profile of an expression, or nil once the profile limit is reached
+func profileFor(environment celEnvironment, exprString string) *expressionProfile {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	key := environment.cacheKey(exprString)
+	if profile, found := profiles[key]; found {
+		return profile
+	}
+	if len(profiles) >= maxProfiledExpressions {
+		return nil
+	}
+	profile := &expressionProfile{environment: environment, expression: exprString}
+	profiles[key] = profile
+	return profile
+}
+
+// profiledProgram times every evaluation of a cached program. The profile is nil
+// for expressions beyond the profile limit; they are still counted in pg_stat_cel.
+type profiledProgram struct {
+	cel.Program
+	profile *expressionProfile
+}
+
+// record counts one evaluation that started at the given time
+func (p *profiledProgram) record(started time.Time, err error) {
+	elapsed := int64(time.Since(started))
+	countStat(statEvaluations, 1)
+	countStat(statEvalNanos, elapsed)
+	if err != nil {
+		countStat(statEvaluationErrors, 1)
+	}
+	if p.profile != nil {
+		p.profile.evaluations.Add(1)
+		p.profile.evalNanos.Add(elapsed)
+	}
+}
+
+func (p *profiledProgram) Eval(input any) (ref.Val, *cel.EvalDetails, error) {
+	started := time.Now()
+	out, details, err := p.Program.Eval(input)
+	p.record(started, err)
+	return out, details, err
+}
+
+func (p *profiledProgram) ContextEval(ctx context.Context, input any) (ref.Val, *cel.EvalDetails, error) {
+	started := time.Now()
+	out, details, err := p.Program.ContextEval(ctx, input)
+	p.record(started, err)
+	return out, details, err
+}
+
+// profileProgram records the compilation of a program that started at the given time
+// and returns the program wrapped to time its evaluations
+func profileProgram(environment celEnvironment, exprString string, prg cel.Program, started time.Time) cel.Program {
+	elapsed := int64(time.Since(started))
+	countStat(statCompilations, 1)
+	countStat(statCompileNanos, elapsed)
+
+	profile := profileFor(environment, exprString)
+	if profile != nil {
+		profile.compilations.Add(1)
+		profile.compileNanos.Add(elapsed)
+	}
+	return &profiledProgram{Program: prg, profile: profile}
+}
+
+// recordCacheHit counts a program served from the program cache
+func recordCacheHit(prg cel.Program) {
+	countStat(statProgramHits, 1)
+	if profiled, ok := prg.(*profiledProgram); ok && profiled.profile != nil {
+		profiled.profile.cacheHits.Add(1)
+	}
+}
+
+// hotExpressions returns the most frequently evaluated expressions, at most limit of them
+func hotExpressions(limit int) []hotExpression {
+	profilesMu.Lock()
+	report := make([]hotExpression, 0, len(profiles))
+	for _, profile := range profiles {
+		evaluations := profile.evaluations.Load()
+		evalNanos := profile.evalNanos.Load()
+		row := hotExpression{
+			Environment:     string(profile.environment),
+			Expression:      profile.expression,
+			Evaluations:     evaluations,
+			CacheHits:       profile.cacheHits.Load(),
+			Compilations:    profile.compilations.Load(),
+			CompileTimeMs:   float64(profile.compileNanos.Load()) / float64(time.Millisecond),
+			TotalEvalTimeMs: float64(evalNanos) / float64(time.Millisecond),
+		}
+		if evaluations > 0 {
+			row.AvgEvalTimeUs = float64(evalNanos) / float64(evaluations) / float64(time.Microsecond)
+		}
+		report = append(report, row)
+	}
+	profilesMu.Unlock()
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Evaluations != report[j].Evaluations {
+			return report[i].Evaluations > report[j].Evaluations
+		}
+		if report[i].Expression != report[j].Expression {
+			return report[i].Expression < report[j].Expression
+		}
+		return report[i].Environment < report[j].Environment
+	})
+	if limit >= 0 && len(report) > limit {
+		report = report[:limit]
+	}
+	return report
+}
+
+// expressionCacheHits returns how often an expression was served from the program cache
+func expressionCacheHits(environment celEnvironment, exprString string) int64 {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	if profile, found := profiles[environment.cacheKey(exprString)]; found {
+		return profile.cacheHits.Load()
+	}
+	return 0
+}
+
+// resetProfiles discards every expression profile
+func resetProfiles() {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	profiles = make(map[string]*expressionProfile)
+}
+
+// statisticsFor summarizes the metrics of one cache
+func statisticsFor[V any](name string, cache *ristretto.Cache[string, V]) cacheStatistics {
+	metrics := cache.Metrics
+	return cacheStatistics{
+		Cache:     name,
+		Hits:      metrics.Hits(),
+		Misses:    metrics.Misses(),
+		HitRatio:  metrics.Ratio(),
+		Entries:   metrics.KeysAdded() - metrics.KeysEvicted(),
+		Bytes:     metrics.CostAdded() - metrics.CostEvicted(),
+		MaxBytes:  cache.MaxCost(),
+		Evictions: metrics.KeysEvicted(),
+	}
+}
+
+//export pg_cel_cache_statistics
+func pg_cel_cache_statistics(errorOut **C.char) *C.char {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	report := []cacheStatistics{
+		statisticsFor("program", programCache),
+		statisticsFor("json", jsonCache),
+	}
+
+	jsonBytes, err := json.Marshal(report)
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("Error marshaling stats: %v", err))
+		return nil
+	}
+
+	return C.CString(string(jsonBytes))
+}
+
+//export pg_cel_hot_expressions
+func pg_cel_hot_expressions(limit C.int, errorOut **C.char) *C.char {
+	jsonBytes, err := json.Marshal(hotExpressions(int(limit)))
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("Error marshaling hot expressions: %v", err))
+		return nil
+	}
+
+	return C.CString(string(jsonBytes))
+}
@@ -0,0 +1,307 @@
+package main
+
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// celRule is a single identified CEL expression within a rule set.
+// Message, MessageExpression and Path are only used by validation rules.
+type celRule struct {
+	ID                string
+	Expression        string
+	Message           string
+	MessageExpression string
+	Path              string
+}
+
+// ruleResult reports the outcome of one matching (or failing) rule
+type ruleResult struct {
+	ID    string          `json:"id"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// jsonValueType is the protobuf JSON representation CEL values convert to
+var jsonValueType = reflect.TypeOf(&structpb.Value{})
+
+// celValueToJSON converts a CEL result into its JSON encoding
+func celValueToJSON(val ref.Val) (json.RawMessage, error) {
+	native, err := val.ConvertToNative(jsonValueType)
+	if err != nil {
+		return nil, err
+	}
+	return protojson.Marshal(native.(*structpb.Value))
+}
+
+// parseRules accepts a rule set as a JSON object mapping ids to expressions,
+// a JSON array of expression strings (ids are 1-based positions), or a JSON
+// array of {"id": ..., "expression": ..., "message": ..., "path": ...} objects
+func parseRules(rulesString string) ([]celRule, error) {
+	var byID map[string]string
+	if err := json.Unmarshal([]byte(rulesString), &byID); err == nil {
+		return parseRuleObject(rulesString)
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal([]byte(rulesString), &entries); err != nil {
+		return nil, fmt.Errorf("rule set parsing error: expected a JSON object or array: %v", err)
+	}
+
+	rules := make([]celRule, 0, len(entries))
+	for i, entry := range entries {
+		rule := celRule{ID: strconv.Itoa(i + 1)}
+
+		var expression string
+		if err := json.Unmarshal(entry, &expression); err == nil {
+			rule.Expression = expression
+			rules = append(rules, rule)
+			continue
+		}
+
+		var object struct {
+			ID                any    `json:"id"`
+			Expression        string `json:"expression"`
+			Message           string `json:"message"`
+			MessageExpression string `json:"message_expr"`
+			Path              string `json:"path"`
+		}
+		if err := json.Unmarshal(entry, &object); err != nil {
+			return nil, fmt.Errorf("rule set parsing error: rule %d: %v", i+1, err)
+		}
+		if object.Expression == "" {
+			return nil, fmt.Errorf("rule set parsing error: rule %d has no expression", i+1)
+		}
+		if object.ID != nil {
+			rule.ID = fmt.Sprintf("%v", object.ID)
+		}
+		rule.Expression = object.Expression
+		rule.Message = object.Message
+		rule.MessageExpression = object.MessageExpression
+		rule.Path = object.Path
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// parseRuleObject reads a {"id": "expression"} rule set keeping the rules in the
+// order their keys appear, so evaluation order does not depend on id spelling
+func parseRuleObject(rulesString string) ([]celRule, error) {
+	decoder := json.NewDecoder(strings.NewReader(rulesString))
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("rule set parsing error: %v", err)
+	}
+
+	rules := make([]celRule, 0)
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("rule set parsing error: %v", err)
+		}
+		var expression string
+		if err := decoder.Decode(&expression); err != nil {
+			return nil, fmt.Errorf("rule set parsing error: rule %v: %v", key, err)
+		}
+		rules = append(rules, celRule{ID: key.(string), Expression: expression})
+	}
+	return rules, nil
+}
+
+// isRuleMatch reports whether a rule result is returned. There is no truthiness:
+// a boolean rule matches only when it is true, and any other non-null value
+// (including 0, "" and []) is returned as the rule's value
+func isRuleMatch(out ref.Val) bool {
+	if matched, ok := out.(types.Bool); ok {
+		return bool(matched)
+	}
+	return out != types.NullValue
+}
+
+// evalRule evaluates a single rule expression against an already parsed document
+func evalRule(expression string, env map[string]any) (ref.Val, error) {
+	prg, err := getJSONProgram(expression, env)
+	if err != nil {
+		return nil, err
+	}
+
+	out, _, err := prg.Eval(env)
+	if err != nil {
+		return nil, fmt.Errorf("CEL evaluation error: %v", err)
+	}
+	return out, nil
+}
+
+// evalRules evaluates every rule against one document, returning matches and errors
+func evalRules(rules []celRule, env map[string]any) []ruleResult {
+	results := make([]ruleResult, 0)
+	for _, rule := range rules {
+		out, err := evalRule(rule.Expression, env)
+		if err != nil {
+			results = append(results, ruleResult{ID: rule.ID, Error: err.Error()})
+			continue
+		}
+		if !isRuleMatch(out) {
+			continue
+		}
+
+		value, err := celValueToJSON(out)
+		if err != nil {
+			results = append(results, ruleResult{ID: rule.ID, Error: fmt.Sprintf("CEL result conversion error: %v", err)})
+			continue
+		}
+		results = append(results, ruleResult{ID: rule.ID, Value: value})
+	}
+	return results
+}
+
+//export pg_cel_eval_rules
+func pg_cel_eval_rules(jsonData *C.char, rulesStr *C.char, errorOut **C.char) *C.char {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	// Convert C strings to Go strings
+	jsonString := C.GoString(jsonData)
+	rulesString := C.GoString(rulesStr)
+
+	rules, err := parseRules(rulesString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	// Parse the document once for all rules
+	env, err := parseDocument(jsonString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	jsonBytes, err := json.Marshal(evalRules(rules, env))
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("Error marshaling rule results: %v", err))
+		return nil
+	}
+
+	return C.CString(string(jsonBytes))
+}
+
+// decisionRule is a prioritised condition with the output it produces when matched
+type decisionRule struct {
+	ID        any    `json:"id"`
+	Priority  int    `json:"priority"`
+	Condition string `json:"condition"`
+	Output    string `json:"output"`
+}
+
+// decisionRuleSet is an ordered set of decision rules with an optional default output
+type decisionRuleSet struct {
+	Rules   []decisionRule `json:"rules"`
+	Default string         `json:"default"`
+}
+
+// parseDecisionRuleSet accepts {"rules": [...], "default": "expr"} or a bare array of rules
+func parseDecisionRuleSet(ruleSetString string) (*decisionRuleSet, error) {
+	ruleSet := &decisionRuleSet{}
+	if err := json.Unmarshal([]byte(ruleSetString), ruleSet); err != nil {
+		if err := json.Unmarshal([]byte(ruleSetString), &ruleSet.Rules); err != nil {
+			return nil, fmt.Errorf("rule set parsing error: expected {\"rules\": [...]} or an array of rules: %v", err)
+		}
+	}
+
+	for i := range ruleSet.Rules {
+		if ruleSet.Rules[i].ID == nil {
+			ruleSet.Rules[i].ID = i + 1
+		}
+		if ruleSet.Rules[i].Condition == "" || ruleSet.Rules[i].Output == "" {
+			return nil, fmt.Errorf("rule set parsing error: rule %v needs both a condition and an output", ruleSet.Rules[i].ID)
+		}
+	}
+
+	// Highest priority first; rules with equal priority keep their declared order
+	sort.SliceStable(ruleSet.Rules, func(i, j int) bool {
+		return ruleSet.Rules[i].Priority > ruleSet.Rules[j].Priority
+	})
+
+	return ruleSet, nil
+}
+
+// decide evaluates conditions in priority order and returns the output of the first match,
+// the default output when nothing matches, or nil when there is no default
+func decide(ruleSet *decisionRuleSet, env map[string]any) (json.RawMessage, error) {
+	output := ruleSet.Default
+	for _, rule := range ruleSet.Rules {
+		out, err := evalRule(rule.Condition, env)
+		if err != nil {
+			return nil, fmt.Errorf("rule %v condition: %v", rule.ID, err)
+		}
+		matched, ok := out.(types.Bool)
+		if !ok {
+			return nil, fmt.Errorf("rule %v condition: expected bool result, got %s", rule.ID, out.Type().TypeName())
+		}
+		if matched {
+			output = rule.Output
+			break
+		}
+	}
+
+	if output == "" {
+		return nil, nil
+	}
+
+	out, err := evalRule(output, env)
+	if err != nil {
+		return nil, fmt.Errorf("decision output: %v", err)
+	}
+	value, err := celValueToJSON(out)
+	if err != nil {
+		return nil, fmt.Errorf("decision output: CEL result conversion error: %v", err)
+	}
+	return value, nil
+}
+
+//export pg_cel_decide
+func pg_cel_decide(ruleSetStr *C.char, jsonData *C.char, errorOut **C.char) *C.char {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	// Convert C strings to Go strings
+	ruleSetString := C.GoString(ruleSetStr)
+	jsonString := C.GoString(jsonData)
+
+	ruleSet, err := parseDecisionRuleSet(ruleSetString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	// Parse the document once for all conditions
+	env, err := parseDocument(jsonString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	value, err := decide(ruleSet, env)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+	if value == nil {
+		// No rule matched and no default: SQL NULL
+		return nil
+	}
+
+	return C.CString(string(value))
+}
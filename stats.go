@@ -0,0 +1,42 @@
+package main
+
+import "C"
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Cluster-wide counters. Backends count here and the C wrapper adds the pending counts
+// to shared memory at the end of every transaction (pg_stat_cel). The order must match
+// the PgCelStat enum in pg_cel_stats.h.
+const (
+	statProgramHits = iota
+	statCompilations
+	statCompileNanos
+	statJSONHits
+	statJSONMisses
+	statEvaluations
+	statEvaluationErrors
+	statEvalNanos
+	statCount
+)
+
+// pendingStats are the counts of this backend not yet added to shared memory
+var pendingStats [statCount]atomic.Int64
+
+// countStat adds to a pending counter
+func countStat(stat int, delta int64) {
+	pendingStats[stat].Add(delta)
+}
+
+//export pg_cel_stats_take
+func pg_cel_stats_take(counters *C.longlong, count C.int) {
+	out := unsafe.Slice(counters, int(count))
+	for i := range out {
+		out[i] = 0
+		if i < statCount {
+			out[i] = C.longlong(pendingStats[i].Swap(0))
+		}
+	}
+}
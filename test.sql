@@ -33,5 +33,10 @@ SELECT cel_eval_string('name.lowerAscii() + "@company.com"', '{"name": "JOHN"}')
 -- Test error handling (should return false/null safely)
 SELECT cel_eval_bool('nonexistent.field == "test"', '{}') AS safe_error_handling;
 
+-- Test multi-rule evaluation (one parse, many rules)
+SELECT rule_id, value FROM cel_eval_rules('{"age": 25, "verified": true}'::jsonb,
+                                          '{"adult": "age >= 18.0", "verified": "verified"}'::jsonb);
+-- Expected: adult | true, verified | true
+
 -- Clear cache for clean state
 SELECT cel_cache_clear() AS cache_cleared;
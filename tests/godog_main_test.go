@@ -8,14 +8,22 @@ import (
 	"github.com/cucumber/godog/colors"
 )
 
-// TestFeatures runs the godog BDD tests
+// TestFeatures runs the godog BDD tests. Scenarios tagged @preloaded need pg_cel_stats in
+// shared_preload_libraries and only run when PG_CEL_PRELOADED is set; scenarios tagged
+// @not_preloaded only run when it is not.
 func TestFeatures(t *testing.T) {
+	tags := "~@preloaded"
+	if os.Getenv("PG_CEL_PRELOADED") != "" {
+		tags = "~@not_preloaded"
+	}
+
 	suite := godog.TestSuite{
 		TestSuiteInitializer: InitializeTestSuite,
 		ScenarioInitializer:  InitializeScenario,
 		Options: &godog.Options{
 			Format:   "pretty",
 			Paths:    []string{"../features"},
+			Tags:     tags,
 			TestingT: t,
 			Output:   colors.Colored(os.Stdout),
 		},
@@ -510,6 +510,46 @@ func (tc *TestContext) iHaveATableWithJSONData(ctx context.Context) (context.Con
 	return ctx, nil
 }
 
+func (tc *TestContext) iHaveATableWithACELValidationTrigger(ctx context.Context) (context.Context, error) {
+	// Products table guarded by cel_validate_trigger rules
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS validated_products (
+			id SERIAL PRIMARY KEY,
+			name TEXT,
+			price NUMERIC
+		)`,
+		`DELETE FROM validated_products`,
+		`DROP TRIGGER IF EXISTS validated_products_cel ON validated_products`,
+		`CREATE TRIGGER validated_products_cel
+			BEFORE INSERT OR UPDATE ON validated_products
+			FOR EACH ROW EXECUTE FUNCTION cel_validate_trigger(
+				'new.price > 0.0',
+				'new.name.size() > 0',
+				'op != "UPDATE" || new.price <= old.price * 2.0')`,
+		`INSERT INTO validated_products (name, price) VALUES ('Widget', 10)`,
+	}
+
+	for _, query := range queries {
+		_, err := tc.db.Exec(query)
+		if err != nil {
+			return ctx, fmt.Errorf("failed to setup validation trigger table: %v", err)
+		}
+	}
+
+	return ctx, nil
+}
+
+func (tc *TestContext) theSessionSettingIs(ctx context.Context, name, value string) (context.Context, error) {
+	// Session settings only apply to one connection, so keep the scenario on a single one
+	tc.db.SetMaxOpenConns(1)
+
+	if _, err := tc.db.Exec(`SELECT set_config($1, $2, false)`, name, value); err != nil {
+		return ctx, fmt.Errorf("failed to set %s: %v", name, err)
+	}
+
+	return ctx, nil
+}
+
 func (tc *TestContext) iExecuteSQL(ctx context.Context, sqlDoc *godog.DocString) (context.Context, error) {
 	tc.lastError = nil
 	tc.sqlResults = make([]map[string]interface{}, 0)
@@ -1056,6 +1096,8 @@ func InitializeScenario(sc *godog.ScenarioContext) {
 	// SQL integration steps
 	sc.Given(`^I have a test table with data$`, tc.iHaveATestTableWithData)
 	sc.Given(`^I have a table with JSON data$`, tc.iHaveATableWithJSONData)
+	sc.Given(`^I have a table with a CEL validation trigger$`, tc.iHaveATableWithACELValidationTrigger)
+	sc.Given(`^the session setting "([^"]*)" is "([^"]*)"$`, tc.theSessionSettingIs)
 	sc.When(`^I execute SQL:$`, tc.iExecuteSQL)
 	sc.Then(`^the SQL result should be "([^"]*)"$`, tc.theSQLResultShouldBe)
 	sc.Then(`^the SQL should return results$`, tc.theSQLShouldReturnResults)
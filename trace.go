@@ -0,0 +1,154 @@
+package main
+
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+)
+
+// traceStep is the value one sub-expression produced during evaluation
+type traceStep struct {
+	ID         int64           `json:"id"`
+	Depth      int             `json:"depth"`
+	Expression string          `json:"expression"`
+	Value      json.RawMessage `json:"value,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// tracer walks the parsed AST and looks up each node in the evaluation state
+type tracer struct {
+	parsed *ast.AST
+	state  interpreter.EvalState
+	env    map[string]any
+	steps  []traceStep
+}
+
+// value returns the recorded value of a node. Variables that were only read as part of a
+// field selection (x in x.y) have no state of their own, so they are taken from the document.
+func (t *tracer) value(expr ast.Expr) (ref.Val, bool) {
+	if value, found := t.state.Value(expr.ID()); found {
+		return value, true
+	}
+	if expr.Kind() == ast.IdentKind {
+		if raw, found := t.env[expr.AsIdent()]; found {
+			return types.DefaultTypeAdapter.NativeToValue(raw), true
+		}
+	}
+	return nil, false
+}
+
+// visit records a node and its operands in source order. Comprehensions are
+// recorded as their macro call (e.g. items.all(i, i > 0)) with the range they iterate.
+func (t *tracer) visit(expr ast.Expr, depth int) {
+	step := traceStep{ID: expr.ID(), Depth: depth}
+	if text, err := unparseExpr(t.parsed, expr, 0); err == nil {
+		step.Expression = text
+	}
+
+	if value, found := t.value(expr); found {
+		if types.IsError(value) {
+			step.Error = fmt.Sprintf("%v", value)
+		} else if encoded, err := celValueToJSON(value); err == nil {
+			step.Value = encoded
+		} else {
+			// Values without a JSON form (e.g. types) are shown by their CEL rendering
+			step.Value, _ = json.Marshal(fmt.Sprintf("%v", value))
+		}
+	}
+	t.steps = append(t.steps, step)
+
+	switch expr.Kind() {
+	case ast.SelectKind:
+		t.visit(expr.AsSelect().Operand(), depth+1)
+	case ast.CallKind:
+		call := expr.AsCall()
+		if call.IsMemberFunction() {
+			t.visit(call.Target(), depth+1)
+		}
+		for _, arg := range call.Args() {
+			t.visit(arg, depth+1)
+		}
+	case ast.ListKind:
+		for _, elem := range expr.AsList().Elements() {
+			t.visit(elem, depth+1)
+		}
+	case ast.MapKind:
+		for _, entry := range expr.AsMap().Entries() {
+			t.visit(entry.AsMapEntry().Key(), depth+1)
+			t.visit(entry.AsMapEntry().Value(), depth+1)
+		}
+	case ast.ComprehensionKind:
+		t.visit(expr.AsComprehension().IterRange(), depth+1)
+	}
+}
+
+// traceExpression evaluates an expression against a document, recording every sub-expression value.
+// Evaluation is exhaustive, so both sides of && and || are reported even when one decides the result.
+func traceExpression(exprString string, env map[string]any) ([]traceStep, error) {
+	// Traced programs carry evaluation state, so they are compiled per call and never cached
+	celEnv, err := createDynamicCELEnv(env)
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %v", err)
+	}
+	celEnv, err = celEnv.Extend(cel.EnableMacroCallTracking())
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %v", err)
+	}
+
+	checked, issues := celEnv.Compile(exprString)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("CEL compilation error: %v", issues.Err())
+	}
+
+	prg, err := celEnv.Program(checked, cel.EvalOptions(cel.OptExhaustiveEval))
+	if err != nil {
+		return nil, fmt.Errorf("CEL program creation error: %v", err)
+	}
+
+	// Evaluation errors are part of the trace rather than a failure of the trace itself
+	_, details, _ := prg.Eval(env)
+	if details == nil {
+		return nil, fmt.Errorf("CEL evaluation error: no evaluation state recorded")
+	}
+
+	walker := &tracer{parsed: checked.NativeRep(), state: details.State(), env: env}
+	walker.visit(checked.NativeRep().Expr(), 0)
+	return walker.steps, nil
+}
+
+//export pg_cel_eval_trace
+func pg_cel_eval_trace(expressionStr *C.char, jsonData *C.char, errorOut **C.char) *C.char {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	// Convert C strings to Go strings
+	exprString := C.GoString(expressionStr)
+	jsonString := C.GoString(jsonData)
+
+	env, err := parseDocument(jsonString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	steps, err := traceExpression(exprString, env)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	jsonBytes, err := json.Marshal(steps)
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("Error marshaling trace: %v", err))
+		return nil
+	}
+
+	return C.CString(string(jsonBytes))
+}
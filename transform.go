@@ -0,0 +1,96 @@
+package main
+
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// transformDocument evaluates an expression against a parsed document and returns the result as JSON
+func transformDocument(exprString string, env map[string]any) (string, error) {
+	out, err := evalRule(exprString, env)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := celValueToJSON(out)
+	if err != nil {
+		return "", fmt.Errorf("CEL result conversion error: %v", err)
+	}
+	return string(value), nil
+}
+
+//export pg_cel_transform
+func pg_cel_transform(jsonData *C.char, expressionStr *C.char, errorOut **C.char) *C.char {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	// Convert C strings to Go strings
+	jsonString := C.GoString(jsonData)
+	exprString := C.GoString(expressionStr)
+
+	env, err := parseDocument(jsonString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	result, err := transformDocument(exprString, env)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	return C.CString(result)
+}
+
+// projectDocument evaluates every field expression of a projection spec against one parsed document
+func projectDocument(spec map[string]string, env map[string]any) (map[string]json.RawMessage, error) {
+	projection := make(map[string]json.RawMessage, len(spec))
+	for field, exprString := range spec {
+		value, err := transformDocument(exprString, env)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %v", field, err)
+		}
+		projection[field] = json.RawMessage(value)
+	}
+	return projection, nil
+}
+
+//export pg_cel_project
+func pg_cel_project(jsonData *C.char, specStr *C.char, errorOut **C.char) *C.char {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	// Convert C strings to Go strings
+	jsonString := C.GoString(jsonData)
+	specString := C.GoString(specStr)
+
+	var spec map[string]string
+	if err := json.Unmarshal([]byte(specString), &spec); err != nil {
+		*errorOut = C.CString(fmt.Sprintf("projection spec parsing error: expected an object of field expressions: %v", err))
+		return nil
+	}
+
+	// Parse the document once for all fields
+	env, err := parseDocument(jsonString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	projection, err := projectDocument(spec, env)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	jsonBytes, err := json.Marshal(projection)
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("Error marshaling projection: %v", err))
+		return nil
+	}
+
+	return C.CString(string(jsonBytes))
+}
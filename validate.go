@@ -0,0 +1,274 @@
+package main
+
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+)
+
+// ruleViolation describes a validation rule that a row or document failed
+type ruleViolation struct {
+	ID         string `json:"id"`
+	Expression string `json:"expression"`
+	Message    string `json:"message,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+// createTriggerCELEnv creates a CEL environment binding the trigger row variables.
+// new and old are dynamic so that rules guarded by op still compile when a row is absent.
+func createTriggerCELEnv() (*cel.Env, error) {
+	return cel.NewEnv(append(celExtensionOptions(),
+		cel.Variable("new", cel.DynType),
+		cel.Variable("old", cel.DynType),
+		cel.Variable("op", cel.StringType),
+	)...)
+}
+
+// getTriggerProgram returns a compiled program for a trigger validation rule
+func getTriggerProgram(exprString string) (cel.Program, error) {
+	cacheKey := programCacheKey(triggerEnvironment, exprString)
+
+	// Try to get compiled program from cache
+	if cachedProgram, found := programCache.Get(cacheKey); found {
+		recordCacheHit(cachedProgram)
+		return cachedProgram, nil
+	}
+
+	started := time.Now()
+	celEnv, err := createTriggerCELEnv()
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %v", err)
+	}
+
+	// Compile the expression (cache miss)
+	ast, issues := celEnv.Compile(exprString)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("CEL compilation error: %v", issues.Err())
+	}
+
+	prg, err := newProgram(celEnv, ast, exprString)
+	if err != nil {
+		return nil, fmt.Errorf("CEL program creation error: %v", err)
+	}
+	prg = profileProgram(triggerEnvironment, exprString, prg, started)
+
+	// Cache the compiled program
+	cacheProgram(cacheKey, triggerEnvironment, exprString, prg, programCost(ast))
+
+	return prg, nil
+}
+
+// validateRow checks every rule against the trigger row; anything but true is a violation
+func validateRow(rules []celRule, row map[string]any) []ruleViolation {
+	violations := make([]ruleViolation, 0)
+	for _, rule := range rules {
+		reason := ""
+
+		prg, err := getTriggerProgram(rule.Expression)
+		if err != nil {
+			reason = err.Error()
+		} else if out, _, err := prg.Eval(row); err != nil {
+			reason = fmt.Sprintf("CEL evaluation error: %v", err)
+		} else if matched, ok := out.(types.Bool); !ok {
+			reason = fmt.Sprintf("expected bool result, got %s", out.Type().TypeName())
+		} else if !matched {
+			reason = "evaluated to false"
+		}
+
+		if reason != "" {
+			violations = append(violations, ruleViolation{ID: rule.ID, Expression: rule.Expression, Reason: reason})
+		}
+	}
+	return violations
+}
+
+//export pg_cel_validate_row
+func pg_cel_validate_row(rowData *C.char, rulesStr *C.char, errorOut **C.char) *C.char {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	// Convert C strings to Go strings
+	rowString := C.GoString(rowData)
+	rulesString := C.GoString(rulesStr)
+
+	rules, err := parseRules(rulesString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	// Trigger rows are unique per call, so they bypass the JSON cache
+	row := map[string]any{"new": nil, "old": nil, "op": ""}
+	if err := json.Unmarshal([]byte(rowString), &row); err != nil {
+		*errorOut = C.CString(fmt.Sprintf("JSON parsing error: %v", err))
+		return nil
+	}
+
+	jsonBytes, err := json.Marshal(validateRow(rules, row))
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("Error marshaling violations: %v", err))
+		return nil
+	}
+
+	return C.CString(string(jsonBytes))
+}
+
+// fieldPath returns the dotted path of an identifier or field selection chain such as user.address.zip
+func fieldPath(expr ast.Expr) (string, bool) {
+	switch expr.Kind() {
+	case ast.IdentKind:
+		return expr.AsIdent(), true
+	case ast.SelectKind:
+		sel := expr.AsSelect()
+		if operand, ok := fieldPath(sel.Operand()); ok {
+			return operand + "." + sel.FieldName(), true
+		}
+	}
+	return "", false
+}
+
+// collectFieldPaths appends the document field paths referenced by an expression in source order.
+// Only paths rooted at a document variable are kept, which skips comprehension variables.
+func collectFieldPaths(expr ast.Expr, roots map[string]any, paths []string) []string {
+	if path, ok := fieldPath(expr); ok {
+		root, _, _ := strings.Cut(path, ".")
+		if _, found := roots[root]; found {
+			paths = append(paths, path)
+		}
+		return paths
+	}
+
+	switch expr.Kind() {
+	case ast.SelectKind:
+		paths = collectFieldPaths(expr.AsSelect().Operand(), roots, paths)
+	case ast.CallKind:
+		call := expr.AsCall()
+		if call.IsMemberFunction() {
+			paths = collectFieldPaths(call.Target(), roots, paths)
+		}
+		for _, arg := range call.Args() {
+			paths = collectFieldPaths(arg, roots, paths)
+		}
+	case ast.ListKind:
+		for _, elem := range expr.AsList().Elements() {
+			paths = collectFieldPaths(elem, roots, paths)
+		}
+	case ast.MapKind:
+		for _, entry := range expr.AsMap().Entries() {
+			paths = collectFieldPaths(entry.AsMapEntry().Key(), roots, paths)
+			paths = collectFieldPaths(entry.AsMapEntry().Value(), roots, paths)
+		}
+	case ast.ComprehensionKind:
+		comp := expr.AsComprehension()
+		paths = collectFieldPaths(comp.IterRange(), roots, paths)
+		paths = collectFieldPaths(comp.LoopStep(), roots, paths)
+		paths = collectFieldPaths(comp.Result(), roots, paths)
+	}
+	return paths
+}
+
+// ruleFieldPath returns the declared path of a rule, or the first document field its expression references
+func ruleFieldPath(rule celRule, env map[string]any) string {
+	if rule.Path != "" {
+		return rule.Path
+	}
+
+	celEnv, err := createCELEnv()
+	if err != nil {
+		return ""
+	}
+	parsed, issues := celEnv.Parse(rule.Expression)
+	if issues != nil && issues.Err() != nil {
+		return ""
+	}
+
+	paths := collectFieldPaths(parsed.NativeRep().Expr(), env, nil)
+	if len(paths) == 0 {
+		return ""
+	}
+	return paths[0]
+}
+
+// ruleMessage returns the literal message of a rule, or the result of its message
+// expression when one is given; the literal text is the fallback if the expression fails
+func ruleMessage(rule celRule, env map[string]any) string {
+	if rule.MessageExpression == "" {
+		return rule.Message
+	}
+
+	out, err := evalRule(rule.MessageExpression, env)
+	if err != nil {
+		return rule.Message
+	}
+	if message, ok := out.(types.String); ok {
+		return string(message)
+	}
+	return rule.Message
+}
+
+// validateDocument checks every rule against a parsed document; anything but true is a violation
+func validateDocument(rules []celRule, env map[string]any) []ruleViolation {
+	violations := make([]ruleViolation, 0)
+	for _, rule := range rules {
+		reason := ""
+
+		out, err := evalRule(rule.Expression, env)
+		if err != nil {
+			reason = err.Error()
+		} else if matched, ok := out.(types.Bool); !ok {
+			reason = fmt.Sprintf("expected bool result, got %s", out.Type().TypeName())
+		} else if !matched {
+			reason = "evaluated to false"
+		}
+
+		if reason != "" {
+			violations = append(violations, ruleViolation{
+				ID:         rule.ID,
+				Expression: rule.Expression,
+				Message:    ruleMessage(rule, env),
+				Path:       ruleFieldPath(rule, env),
+				Reason:     reason,
+			})
+		}
+	}
+	return violations
+}
+
+//export pg_cel_validate
+func pg_cel_validate(jsonData *C.char, rulesStr *C.char, errorOut **C.char) *C.char {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	// Convert C strings to Go strings
+	jsonString := C.GoString(jsonData)
+	rulesString := C.GoString(rulesStr)
+
+	rules, err := parseRules(rulesString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	// Parse the document once for all rules
+	env, err := parseDocument(jsonString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return nil
+	}
+
+	jsonBytes, err := json.Marshal(validateDocument(rules, env))
+	if err != nil {
+		*errorOut = C.CString(fmt.Sprintf("Error marshaling violations: %v", err))
+		return nil
+	}
+
+	return C.CString(string(jsonBytes))
+}
@@ -0,0 +1,87 @@
+package main
+
+import "C"
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+)
+
+// documentType returns the type a JSON document binds a value declared with celType to:
+// numbers are doubles, arrays list(dyn) and objects map(string, dyn). Types no JSON value
+// has are rejected, since programs warmed for them would never be looked up.
+func documentType(celType *cel.Type) (*cel.Type, error) {
+	switch celType.Kind() {
+	case types.IntKind, types.UintKind, types.DoubleKind:
+		return cel.DoubleType, nil
+	case types.StringKind, types.BoolKind, types.NullTypeKind:
+		return celType, nil
+	case types.ListKind:
+		return cel.ListType(cel.DynType), nil
+	case types.MapKind:
+		if celType.Parameters()[0].Kind() == types.StringKind {
+			return cel.MapType(cel.StringType, cel.DynType), nil
+		}
+	}
+	return nil, fmt.Errorf("documents never bind type %s", celType)
+}
+
+// warmDeclarations returns the variables documents with the declared top-level keys are
+// bound to, so that warmed programs have the cache keys later evaluations look up. With
+// pg_cel.document_variable set the whole document is that one dyn variable; otherwise the
+// keys that are CEL identifiers are declared alongside the root variable.
+func warmDeclarations(declarations map[string]*cel.Type) (map[string]*cel.Type, error) {
+	if documentVariable != "" {
+		return map[string]*cel.Type{documentVariable: cel.DynType}, nil
+	}
+
+	variables := make(map[string]*cel.Type, len(declarations)+1)
+	variables[rootVariable] = cel.MapType(cel.StringType, cel.DynType)
+	for name, celType := range declarations {
+		if !isCELIdentifier(name) {
+			continue
+		}
+		bound, err := documentType(celType)
+		if err != nil {
+			return nil, fmt.Errorf("declarations parsing error: variable %q: %v", name, err)
+		}
+		variables[name] = bound
+	}
+	return variables, nil
+}
+
+// warmExpression compiles an expression into the program cache for documents with the
+// declared top-level keys, reporting whether it compiled
+func warmExpression(exprString string, variables map[string]*cel.Type) bool {
+	_, err := getDocumentProgram(exprString, variables)
+	return err == nil
+}
+
+//export pg_cel_cache_warm
+func pg_cel_cache_warm(expressionStr *C.char, declarationsStr *C.char, errorOut **C.char) C.int {
+	// Ensure caches are initialized
+	ensureCachesInitialized()
+
+	// Convert C strings to Go strings
+	exprString := C.GoString(expressionStr)
+	declarationsString := C.GoString(declarationsStr)
+
+	declarations, err := parseDeclarations(declarationsString)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return 0
+	}
+	variables, err := warmDeclarations(declarations)
+	if err != nil {
+		*errorOut = C.CString(err.Error())
+		return 0
+	}
+
+	// Expressions that do not compile are skipped; evaluating them reports the error
+	if !warmExpression(exprString, variables) {
+		return 0
+	}
+	return 1
+}